@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Autenticação: com APP_AUTH_TOKEN definido, as rotas /api/* exigem
+// "Authorization: Bearer <token>" ou basic auth com o token como senha.
+// AUTH_PROTECT_UI=true estende a proteção à interface web. /healthz fica
+// sempre aberto para as sondas do orquestrador.
+
+func authToken() string {
+	return os.Getenv("APP_AUTH_TOKEN")
+}
+
+// validAuth confere o token do cabeçalho em tempo constante
+func validAuth(r *http.Request) bool {
+	token := authToken()
+	var given string
+	if _, pass, ok := r.BasicAuth(); ok {
+		given = pass
+	} else if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = bearer
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// requiresAuth decide quais rotas passam pela verificação
+func requiresAuth(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case path == "/healthz":
+		return false
+	case path == "/api/renew":
+		// Um token de renovação válido substitui a credencial geral
+		return !validRenewToken(r.FormValue("token"), r.FormValue("id"))
+	case strings.HasPrefix(path, "/api/"):
+		return true
+	}
+	return authProtectUI
+}
+
+// authMiddleware aplica a verificação a todas as rotas do mux
+func authMiddleware(next http.Handler) http.Handler {
+	if authToken() == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requiresAuth(r) && !validAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="temp-mail"`)
+			err := errorf(http.StatusUnauthorized, "não autorizado")
+			if wantsJSON(r) {
+				writeJSONError(w, err)
+			} else {
+				writeError(w, err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
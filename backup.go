@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Backup e restauração em JSON, para migrar entre deploys sem copiar o arquivo
+// do SQLite. /api/backup devolve todas as linhas de emails; /api/restore grava
+// um backup desses. As regras na Cloudflare não são tocadas: o rule_id é
+// preservado, então o destino deve usar a mesma zona (confira com /api/reconcile).
+// As duas rotas exigem APP_AUTH_TOKEN, mesmo que o resto da API esteja aberto.
+// Com DATA_ENCRYPTION_KEY o backup leva os destinos decifrados e a restauração
+// os cifra com a chave do deploy de destino (crypto.go).
+
+const backupVersion = 1
+
+// Backup é o formato do arquivo
+type Backup struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Emails    []BackupEmail `json:"emails"`
+}
+
+// BackupEmail é uma linha de emails com todas as colunas. O id é só
+// informativo: a restauração grava linhas novas com o AUTOINCREMENT do destino.
+type BackupEmail struct {
+	ID           int        `json:"id"`
+	Alias        string     `json:"alias"`
+	RuleID       string     `json:"rule_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at"` // nulo em registros antigos
+	Status       string     `json:"status"`
+	RuleTag      string     `json:"rule_tag"`
+	RulePriority int        `json:"rule_priority"`
+	RuleEnabled  bool       `json:"rule_enabled"`
+	Tier         string     `json:"tier"`
+	Destination  string     `json:"destination"`
+	TTLSeconds   int        `json:"ttl_seconds"`
+	AutoRenew    bool       `json:"auto_renew"`
+	Matcher      string     `json:"matcher"`
+	LastError    string     `json:"last_error"`
+	Tags         string     `json:"tags"`
+	Note         string     `json:"note"`
+	DeletedAt    *time.Time `json:"deleted_at"`
+	Notified     bool       `json:"notified"`
+}
+
+// backupColumns é a ordem usada por Scan e pelos INSERT/UPDATE da restauração
+var backupColumns = []string{"alias", "rule_id", "created_at", "expires_at", "status", "rule_tag", "rule_priority", "rule_enabled",
+	"tier", "destination", "ttl_seconds", "auto_renew", "matcher", "last_error", "tags", "note", "deleted_at", "notified"}
+
+func (e BackupEmail) values() []interface{} {
+	return []interface{}{e.Alias, e.RuleID, e.CreatedAt.UTC(), nullableTime(e.ExpiresAt), e.Status, e.RuleTag, e.RulePriority, e.RuleEnabled,
+		e.Tier, sealField(e.Destination), e.TTLSeconds, e.AutoRenew, e.Matcher, e.LastError, e.Tags, e.Note, nullableTime(e.DeletedAt), e.Notified}
+}
+
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// RestoreResult resume a restauração; Skipped traz o alias e o motivo
+type RestoreResult struct {
+	Inserted int           `json:"inserted"`
+	Updated  int           `json:"updated"`
+	Skipped  []RestoreSkip `json:"skipped"`
+}
+
+type RestoreSkip struct {
+	ID     int    `json:"id"` // id no backup
+	Alias  string `json:"alias"`
+	Reason string `json:"reason"`
+}
+
+// backupAllowed responde 403 quando não há APP_AUTH_TOKEN: sem ele a rota
+// entregaria (ou sobrescreveria) o banco inteiro a qualquer um
+func backupAllowed(w http.ResponseWriter) bool {
+	if authToken() == "" {
+		writeJSONError(w, errorf(http.StatusForbidden, "backup e restauração exigem APP_AUTH_TOKEN"))
+		return false
+	}
+	return true
+}
+
+func loadBackup() (Backup, error) {
+	b := Backup{Version: backupVersion, CreatedAt: time.Now().UTC(), Emails: []BackupEmail{}}
+	rows, err := db.Query(`SELECT id, alias, IFNULL(rule_id, ''), created_at, expires_at, IFNULL(status, 'active'),
+		IFNULL(rule_tag, ''), IFNULL(rule_priority, 0), IFNULL(rule_enabled, 0), IFNULL(tier, ''), IFNULL(destination, ''),
+		IFNULL(ttl_seconds, 0), IFNULL(auto_renew, 0), IFNULL(matcher, 'literal'), IFNULL(last_error, ''), IFNULL(tags, ''),
+		IFNULL(note, ''), deleted_at, IFNULL(notified, 0)
+		FROM emails ORDER BY id`)
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e BackupEmail
+		var expiresAt, deletedAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.Alias, &e.RuleID, &e.CreatedAt, &expiresAt, &e.Status, &e.RuleTag, &e.RulePriority, &e.RuleEnabled,
+			&e.Tier, &e.Destination, &e.TTLSeconds, &e.AutoRenew, &e.Matcher, &e.LastError, &e.Tags, &e.Note, &deletedAt, &e.Notified); err != nil {
+			return b, err
+		}
+		if e.Destination, err = openField(e.Destination); err != nil {
+			return b, err
+		}
+		if expiresAt.Valid {
+			e.ExpiresAt = &expiresAt.Time
+		}
+		if deletedAt.Valid {
+			e.DeletedAt = &deletedAt.Time
+		}
+		b.Emails = append(b.Emails, e)
+	}
+	return b, rows.Err()
+}
+
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	if !backupAllowed(w) {
+		return
+	}
+	b, err := loadBackup()
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="temp-mail-backup-`+b.CreatedAt.Format("20060102-150405")+`.json"`)
+	writeJSON(w, http.StatusOK, b)
+}
+
+// restoreBackup grava o backup em uma única transação. Uma linha é
+// identificada por alias + created_at: a mesma linha já restaurada é pulada, o
+// que torna a restauração idempotente. Uma linha ativa/inativa cujo alias já
+// está ativo/inativo aqui também é pulada. Com force, nos dois casos a linha
+// existente é sobrescrita com os dados do backup. Linhas de histórico
+// (deleted, expired, failed) de um alias já existente entram como novas.
+func restoreBackup(b Backup, force bool) (RestoreResult, error) {
+	result := RestoreResult{Skipped: []RestoreSkip{}}
+	if b.Version != backupVersion {
+		return result, errorf(http.StatusBadRequest, "versão de backup não suportada: %d", b.Version)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(backupColumns)), ", ")
+	insert := "INSERT INTO emails (" + strings.Join(backupColumns, ", ") + ") VALUES (" + placeholders + ")"
+	update := "UPDATE emails SET " + strings.Join(backupColumns, " = ?, ") + " = ? WHERE id = ?"
+
+	for _, e := range b.Emails {
+		e.Alias = strings.ToLower(strings.TrimSpace(e.Alias))
+		if e.Matcher == "" {
+			e.Matcher = matcherLiteral
+		}
+		skip := RestoreSkip{ID: e.ID, Alias: e.Alias}
+		if e.Alias == "" || !validStatuses[e.Status] || e.CreatedAt.IsZero() {
+			skip.Reason = "linha inválida (alias, status ou created_at)"
+			result.Skipped = append(result.Skipped, skip)
+			continue
+		}
+		live := e.Status == "active" || e.Status == "inactive"
+
+		var existing int
+		err := tx.QueryRow("SELECT id FROM emails WHERE alias = ? AND datetime(created_at) = datetime(?)",
+			e.Alias, e.CreatedAt.UTC().Format("2006-01-02 15:04:05")).Scan(&existing)
+		if err == sql.ErrNoRows && live {
+			err = tx.QueryRow("SELECT id FROM emails WHERE alias = ? AND status IN ('active', 'inactive')", e.Alias).Scan(&existing)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return result, err
+		}
+
+		// Uma violação do índice de aliases ativos desfaz só o comando, não a
+		// transação: a linha é pulada e o restante segue
+		switch {
+		case existing == 0:
+			_, err := tx.Exec(insert, e.values()...)
+			if isUniqueViolation(err) {
+				skip.Reason = "alias já está ativo"
+				result.Skipped = append(result.Skipped, skip)
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+			result.Inserted++
+		case force:
+			_, err := tx.Exec(update, append(e.values(), existing)...)
+			if isUniqueViolation(err) {
+				skip.Reason = "alias já está ativo em outra linha"
+				result.Skipped = append(result.Skipped, skip)
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+			result.Updated++
+		default:
+			skip.Reason = "alias já existe (use force para sobrescrever)"
+			result.Skipped = append(result.Skipped, skip)
+		}
+	}
+	return result, tx.Commit()
+}
+
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	if !backupAllowed(w) {
+		return
+	}
+	var b Backup
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&b); err != nil {
+		writeJSONError(w, errorf(http.StatusBadRequest, "JSON inválido: %v", err))
+		return
+	}
+	result, err := restoreBackup(b, r.URL.Query().Get("force") == "true")
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	slog.Info("Backup restaurado", "inserted", result.Inserted, "updated", result.Updated, "skipped", len(result.Skipped))
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Vazão de criação e da limpeza contra o fakeCF e um SQLite em memória. Dão a
+// linha de base para medir retries, concorrência e reuso de conexões:
+//
+//	go test -run '^$' -bench 'Generate|CleanupSweep' -benchmem
+//
+// A carga contra um servidor de verdade fica em cmd/loadgen (build tag loadgen).
+
+func BenchmarkGenerate(b *testing.B) {
+	setupTest(b)
+	ctx := context.Background()
+
+	b.Run("createEmail", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := createEmail(ctx, GenerateRequest{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// O caminho HTTP inteiro, com requisições simultâneas como numa rajada
+	b.Run("handler", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				r := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(url.Values{"ttl": {"1h"}}.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				handleGenerate(w, r)
+				if w.Code != http.StatusSeeOther {
+					b.Errorf("HTTP %d: %s", w.Code, w.Body)
+					return
+				}
+			}
+		})
+	})
+}
+
+// Um ciclo de limpeza com benchSweepSize aliases vencidos
+const benchSweepSize = 100
+
+func BenchmarkCleanupSweep(b *testing.B) {
+	cf := setupTest(b)
+	ctx := context.Background()
+	expired := time.Now().Add(-time.Minute)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		cf.mu.Lock()
+		for j := 0; j < benchSweepSize; j++ {
+			alias := fmt.Sprintf("sweep%d-%d@x.com", i, j)
+			ruleID := fmt.Sprintf("sweep%d-%d", i, j)
+			cf.rules[ruleID] = CFRule{ID: ruleID, Name: "TempMail-" + alias, Enabled: true}
+			if _, err := db.Exec("INSERT INTO emails (alias, rule_id, status, expires_at) VALUES (?, ?, 'active', ?)", alias, ruleID, expired); err != nil {
+				cf.mu.Unlock()
+				b.Fatal(err)
+			}
+		}
+		cf.mu.Unlock()
+		b.StartTimer()
+
+		checkExpiredEmails(ctx, time.Now())
+	}
+	b.StopTimer()
+
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM emails WHERE status = 'active'").Scan(&left); err != nil {
+		b.Fatal(err)
+	}
+	if left != 0 {
+		b.Errorf("%d aliases vencidos continuam ativos", left)
+	}
+	b.ReportMetric(float64(benchSweepSize*b.N)/b.Elapsed().Seconds(), "aliases/s")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BulkRequest aplica uma mesma ação a vários emails de uma vez
+type BulkRequest struct {
+	IDs    []int  `json:"ids"`
+	Action string `json:"action"`  // delete, toggle ou renew
+	Reason string `json:"reason"`  // motivo registrado no audit_log (delete)
+	DryRun bool   `json:"dry_run"` // delete: só lista o que seria apagado
+}
+
+// BulkResult é o resultado da ação para um id
+type BulkResult struct {
+	ID     int    `json:"id"`
+	OK     bool   `json:"ok"`
+	Alias  string `json:"alias,omitempty"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleBulk executa a ação id por id: uma falha (ex.: na Cloudflare) fica
+// registrada no resultado daquele id e não interrompe os demais. Com dry_run
+// (no corpo ou ?dry_run=true) o delete só confere cada id e devolve os aliases
+// que seriam apagados, sem tocar na Cloudflare nem no banco.
+func handleBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+
+	var req BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, errorf(http.StatusBadRequest, "JSON inválido: %v", err))
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeJSONError(w, errorf(http.StatusBadRequest, "nenhum id informado"))
+		return
+	}
+	if len(req.IDs) > maxBulkIDs {
+		writeJSONError(w, errorf(http.StatusBadRequest, "máximo de %d ids por requisição", maxBulkIDs))
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		req.DryRun = true
+	}
+	if req.DryRun && req.Action != "delete" {
+		writeJSONError(w, errorf(http.StatusBadRequest, "dry_run só se aplica à ação delete"))
+		return
+	}
+
+	ctx := r.Context()
+	reason := strings.TrimSpace(req.Reason)
+	if req.DryRun {
+		results := make([]BulkResult, 0, len(req.IDs))
+		for _, id := range req.IDs {
+			results = append(results, previewDelete(id, reason))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"dry_run": true, "results": results})
+		return
+	}
+
+	var apply func(id string) (string, error)
+	switch req.Action {
+	case "delete":
+		apply = func(id string) (string, error) {
+			return "deleted", deleteEmail(ctx, id, reason)
+		}
+	case "toggle":
+		apply = func(id string) (string, error) {
+			return toggleEmail(ctx, id)
+		}
+	case "renew":
+		apply = func(id string) (string, error) {
+			renewed, err := renewEmail(id)
+			if err == nil && !renewed {
+				err = fmt.Errorf("email não encontrado ou não está ativo")
+			}
+			return "active", err
+		}
+	default:
+		writeJSONError(w, errorf(http.StatusBadRequest, "ação inválida: %q (use delete, toggle ou renew)", req.Action))
+		return
+	}
+
+	results := make([]BulkResult, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		status, err := apply(strconv.Itoa(id))
+		if err != nil {
+			results = append(results, BulkResult{ID: id, Error: err.Error()})
+			continue
+		}
+		results = append(results, BulkResult{ID: id, OK: true, Status: status})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// previewDelete confere o que deleteEmail exigiria (motivo e linha existente)
+// e devolve o alias que seria apagado
+func previewDelete(id int, reason string) BulkResult {
+	if reason == "" && requireDeleteReason {
+		return BulkResult{ID: id, Error: "Informe o motivo da exclusão (reason)"}
+	}
+	entry, err := getEmail(strconv.Itoa(id))
+	if err == sql.ErrNoRows {
+		return BulkResult{ID: id, Error: "email não encontrado"}
+	}
+	if err != nil {
+		return BulkResult{ID: id, Error: err.Error()}
+	}
+	return BulkResult{ID: id, OK: true, Alias: entry.Alias, Status: "deleted"}
+}
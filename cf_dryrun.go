@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// Modo dry-run (CF_DRY_RUN=true) para desenvolvimento local: criar, pausar e
+// apagar regras só registra no log o que seria feito e devolve ids falsos,
+// sem nenhuma requisição à Cloudflare. O restante do app roda normalmente
+// sobre o SQLite. Consultas que dependem de dados reais da Cloudflare
+// (listagem, catch-all, destinos, analytics) falham com errCFDryRun.
+
+var cfDryRun bool
+
+var errCFDryRun = errors.New("indisponível com CF_DRY_RUN ativo")
+
+// initCFDryRun lê CF_DRY_RUN uma única vez; roda antes de validateConfig, que
+// dispensa o token e a zona nesse modo
+func initCFDryRun() {
+	cfDryRun = envBool("CF_DRY_RUN", false)
+	if cfDryRun {
+		slog.Warn("CF_DRY_RUN ativo: nenhuma chamada será feita à Cloudflare")
+	}
+}
+
+// dryRunRuleID gera um id estável para o alias, no formato dos ids da Cloudflare
+func dryRunRuleID(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return "dryrun" + hex.EncodeToString(sum[:13])
+}
+
+// dryRunRule monta a regra que a Cloudflare devolveria para a requisição
+func dryRunRule(id string, req CFRequest) CFRule {
+	slog.Info("CF_DRY_RUN: regra não enviada", "rule_id", id, "name", req.Name, "enabled", req.Enabled, "matchers", req.Matchers, "actions", req.Actions)
+	return CFRule{ID: id, Name: req.Name, Enabled: req.Enabled, Matchers: req.Matchers, Actions: req.Actions}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buffer circular com os últimos erros da API da Cloudflare, para diagnóstico
+// rápido (token expirado, cota) sem procurar nos logs.
+
+type CFErrorRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Method    string    `json:"method"`
+	Status    int       `json:"status,omitempty"` // 0 = erro de rede
+	Message   string    `json:"message"`
+}
+
+var (
+	cfErrorsMu  sync.Mutex
+	cfErrors    []CFErrorRecord
+	cfErrorsMax = 50 // CF_ERROR_BUFFER
+)
+
+// initCFErrors lê CF_ERROR_BUFFER; roda depois de initLogger para o erro de
+// configuração sair no formato certo
+func initCFErrors() {
+	cfErrorsMax = envInt("CF_ERROR_BUFFER", cfErrorsMax)
+	if cfErrorsMax < 1 {
+		fatal("CF_ERROR_BUFFER deve ser ao menos 1", "value", cfErrorsMax)
+	}
+}
+
+func recordCFError(method string, err error) {
+	rec := CFErrorRecord{Timestamp: time.Now().UTC(), Method: method, Message: redactCF(err.Error())}
+	var cfErr *CFError
+	if errors.As(err, &cfErr) {
+		rec.Status = cfErr.Status
+	}
+
+	cfErrorsMu.Lock()
+	defer cfErrorsMu.Unlock()
+	cfErrors = append(cfErrors, rec)
+	if len(cfErrors) > cfErrorsMax {
+		cfErrors = cfErrors[len(cfErrors)-cfErrorsMax:]
+	}
+}
+
+// redactCF remove o token e os IDs de conta/zona das mensagens guardadas
+func redactCF(msg string) string {
+	for _, key := range []string{"CF_API_TOKEN", "CF_ZONE_ID", "CF_ACCOUNT_ID"} {
+		if v := os.Getenv(key); v != "" {
+			msg = strings.ReplaceAll(msg, v, "["+key+"]")
+		}
+	}
+	return msg
+}
+
+// handleCFErrors lista os erros recentes, do mais novo para o mais antigo
+func handleCFErrors(w http.ResponseWriter, r *http.Request) {
+	cfErrorsMu.Lock()
+	list := make([]CFErrorRecord, 0, len(cfErrors))
+	for i := len(cfErrors) - 1; i >= 0; i-- {
+		list = append(list, cfErrors[i])
+	}
+	cfErrorsMu.Unlock()
+
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Estatísticas de roteamento obtidas da API de analytics (GraphQL) da Cloudflare.
+// Os resultados ficam em cache por alguns minutos para não estourar o limite da API.
+
+const cfStatsQuery = `query ($zoneTag: string, $start: Time, $end: Time) {
+  viewer {
+    zones(filter: {zoneTag: $zoneTag}) {
+      emailRoutingAdaptiveGroups(limit: 10000, filter: {datetime_geq: $start, datetime_leq: $end}) {
+        count
+        dimensions { status }
+      }
+    }
+  }
+}`
+
+type CFStats struct {
+	Period    string         `json:"period"`
+	Since     time.Time      `json:"since"`
+	Until     time.Time      `json:"until"`
+	Forwarded int            `json:"forwarded"`
+	Dropped   int            `json:"dropped"`
+	Failed    int            `json:"failed"`
+	ByStatus  map[string]int `json:"by_status"`
+	CachedAt  time.Time      `json:"cached_at"`
+}
+
+// O cache guarda o último resultado por período; cfStatsFlights guarda a busca
+// em andamento, que os pedidos simultâneos do mesmo período aguardam em vez de
+// repetir. O mutex nunca fica preso durante a chamada à Cloudflare.
+var (
+	cfStatsMu      sync.Mutex
+	cfStatsCache   = map[string]CFStats{}
+	cfStatsFlights = map[string]*cfStatsFlight{}
+)
+
+type cfStatsFlight struct {
+	done  chan struct{}
+	stats CFStats
+	err   error
+}
+
+func handleCFStats(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "24h"
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		http.Error(w, "period inválido: "+period, http.StatusBadRequest)
+		return
+	}
+
+	stats, err := getCFStats(r.Context(), period, d)
+	if err != nil {
+		http.Error(w, "Erro Cloudflare: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func getCFStats(ctx context.Context, period string, d time.Duration) (CFStats, error) {
+	cfStatsMu.Lock()
+	if cached, ok := cfStatsCache[period]; ok && time.Since(cached.CachedAt) < cfStatsCacheTTL {
+		cfStatsMu.Unlock()
+		return cached, nil
+	}
+	flight, running := cfStatsFlights[period]
+	if !running {
+		flight = &cfStatsFlight{done: make(chan struct{})}
+		cfStatsFlights[period] = flight
+	}
+	cfStatsMu.Unlock()
+
+	if !running {
+		// A busca é compartilhada: não é cancelada se quem a iniciou desistir
+		until := time.Now().UTC()
+		flight.stats, flight.err = fetchCFStats(context.WithoutCancel(ctx), until.Add(-d), until)
+		flight.stats.Period = period
+
+		cfStatsMu.Lock()
+		if flight.err == nil {
+			cfStatsCache[period] = flight.stats
+		}
+		delete(cfStatsFlights, period)
+		cfStatsMu.Unlock()
+		close(flight.done)
+	}
+
+	select {
+	case <-flight.done:
+		return flight.stats, flight.err
+	case <-ctx.Done():
+		return CFStats{}, ctx.Err()
+	}
+}
+
+func fetchCFStats(ctx context.Context, since, until time.Time) (CFStats, error) {
+	if cfDryRun {
+		return CFStats{}, errCFDryRun
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"query": cfStatsQuery,
+		"variables": map[string]string{
+			"zoneTag": os.Getenv("CF_ZONE_ID"),
+			"start":   since.Format(time.RFC3339),
+			"end":     until.Format(time.RFC3339),
+		},
+	})
+
+	var gqlResp struct {
+		Data struct {
+			Viewer struct {
+				Zones []struct {
+					Groups []struct {
+						Count      int `json:"count"`
+						Dimensions struct {
+							Status string `json:"status"`
+						} `json:"dimensions"`
+					} `json:"emailRoutingAdaptiveGroups"`
+				} `json:"zones"`
+			} `json:"viewer"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	// O GraphQL não usa o envelope success/result da API REST, mas passa pelos
+	// mesmos retries: 429 e 5xx viram CFError e são repetidos
+	_, err := retryCFRequest(ctx, "POST", func() (CFResultInfo, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", cfBaseURL+"/graphql", bytes.NewReader(payload))
+		if err != nil {
+			return CFResultInfo{}, err
+		}
+		setCFHeaders(req)
+
+		resp, err := cfHTTPClient.Do(req)
+		if err != nil {
+			return CFResultInfo{}, err
+		}
+		defer resp.Body.Close()
+		respBytes, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return CFResultInfo{}, &CFError{
+				Status:     resp.StatusCode,
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+				Message:    "limite de requisições da Cloudflare atingido (HTTP 429)",
+			}
+		}
+		if err := json.Unmarshal(respBytes, &gqlResp); err != nil || resp.StatusCode >= 500 {
+			return CFResultInfo{}, &CFError{Status: resp.StatusCode, Message: fmt.Sprintf("resposta inválida da API de analytics (HTTP %d)", resp.StatusCode)}
+		}
+		return CFResultInfo{}, nil
+	})
+	if err != nil {
+		return CFStats{}, err
+	}
+	if len(gqlResp.Errors) > 0 {
+		return CFStats{}, fmt.Errorf("%s", gqlResp.Errors[0].Message)
+	}
+
+	stats := CFStats{Since: since, Until: until, ByStatus: map[string]int{}, CachedAt: time.Now()}
+	for _, zone := range gqlResp.Data.Viewer.Zones {
+		for _, g := range zone.Groups {
+			status := g.Dimensions.Status
+			stats.ByStatus[status] += g.Count
+
+			switch s := strings.ToLower(status); {
+			case strings.Contains(s, "deliver"), strings.Contains(s, "forward"):
+				stats.Forwarded += g.Count
+			case strings.Contains(s, "drop"), strings.Contains(s, "reject"):
+				stats.Dropped += g.Count
+			default:
+				stats.Failed += g.Count
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Pedidos simultâneos do mesmo período esperam uma única busca, e um 5xx da
+// API de analytics é repetido como nas outras chamadas à Cloudflare
+func TestCFStatsSharedFetchWithRetries(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+			return
+		}
+		<-release
+		w.Write([]byte(`{"data":{"viewer":{"zones":[{"emailRoutingAdaptiveGroups":[{"count":3,"dimensions":{"status":"delivered"}}]}]}}}`))
+	}))
+	defer srv.Close()
+
+	oldURL, oldClient, oldAttempts := cfBaseURL, cfHTTPClient, cfMaxAttempts
+	cfBaseURL, cfHTTPClient, cfMaxAttempts = srv.URL, srv.Client(), 2
+	cfStatsMu.Lock()
+	cfStatsCache = map[string]CFStats{}
+	cfStatsMu.Unlock()
+	t.Cleanup(func() { cfBaseURL, cfHTTPClient, cfMaxAttempts = oldURL, oldClient, oldAttempts })
+
+	var wg sync.WaitGroup
+	results := make([]CFStats, 5)
+	errs := make([]error, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = getCFStats(context.Background(), "1h", time.Hour)
+		}(i)
+	}
+	// Quem não iniciou a busca não fica preso no mutex enquanto ela roda
+	time.Sleep(700 * time.Millisecond)
+	cfStatsMu.Lock()
+	cfStatsMu.Unlock()
+	close(release)
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil || results[i].Forwarded != 3 {
+			t.Errorf("pedido %d: %+v, erro %v", i, results[i], errs[i])
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d chamadas à API, esperado 2 (uma falha e uma nova tentativa)", n)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Identificação do cliente para a deduplicação e o limite de criação. Atrás de
+// um proxy o RemoteAddr é o do proxy (ou vazio/"@" no socket Unix), o que
+// juntaria todos os usuários em um só. TRUSTED_PROXIES lista IPs/CIDRs
+// (ex.: "10.0.0.0/8,127.0.0.1") cujo X-Forwarded-For é aceito; conexões pelo
+// LISTEN_SOCKET vêm sempre do proxy local e também são confiáveis.
+
+var trustedProxies []*net.IPNet
+
+func initTrustedProxies() {
+	raw := os.Getenv("TRUSTED_PROXIES")
+	if raw == "" {
+		return
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Um IP sozinho vira uma rede /32 (ou /128)
+		if ip := net.ParseIP(entry); ip != nil {
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			trustedProxies = append(trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			fatal("TRUSTED_PROXIES inválido", "value", entry)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP retorna o IP de origem: o da conexão, ou, quando ela vem de um
+// proxy confiável, o último endereço não confiável do X-Forwarded-For (os da
+// esquerda podem ter sido forjados pelo cliente). Vazio quando não há como
+// saber, ex.: socket Unix sem X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer != nil && !isTrustedProxy(peer) {
+		return peer.String()
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if !isTrustedProxy(ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	if peer != nil {
+		return peer.String()
+	}
+	return ""
+}
+
+// clientIdentity identifica quem faz a requisição: o usuário do basic auth
+// quando a requisição está autenticada com APP_AUTH_TOKEN, senão o IP. O
+// token é um só para todos, então só o nome de usuário distingue clientes.
+// Vazio quando não há identidade por cliente.
+func clientIdentity(r *http.Request) string {
+	if authToken() != "" {
+		if user, _, ok := r.BasicAuth(); ok && user != "" && validAuth(r) {
+			return "user:" + user
+		}
+	}
+	if ip := clientIP(r); ip != "" {
+		return "ip:" + ip
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies = []*net.IPNet{proxies}
+	t.Cleanup(func() { trustedProxies = nil })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"conexão direta", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"XFF de cliente direto é ignorado", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"proxy confiável", "10.0.0.2:5000", "198.51.100.1", "198.51.100.1"},
+		{"proxies encadeados", "10.0.0.2:5000", "198.51.100.1, 10.0.0.9", "198.51.100.1"},
+		{"entrada forjada à esquerda", "10.0.0.2:5000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"proxy sem XFF", "10.0.0.2:5000", "", "10.0.0.2"},
+		{"socket Unix com XFF", "@", "198.51.100.1", "198.51.100.1"},
+		{"socket Unix sem XFF", "", "", ""},
+		{"socket Unix com XFF inválido", "@", "lixo", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/generate", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, esperado %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Sem identidade por cliente cada generate cria o seu próprio alias
+func TestDedupNeedsClientIdentity(t *testing.T) {
+	oldWindow := dedupWindow
+	dedupWindow = time.Minute
+	t.Cleanup(func() { dedupWindow = oldWindow })
+	trustedProxies = nil
+	dedupCache = map[string]*dedupEntry{}
+	t.Cleanup(func() { dedupCache = map[string]*dedupEntry{} })
+
+	calls := int64(0)
+	create := func() (int64, error) {
+		calls++
+		return calls, nil
+	}
+	req := GenerateRequest{Prefix: "igual"}
+
+	for _, remote := range []string{"@", "@"} {
+		r := httptest.NewRequest("POST", "/api/generate", nil)
+		r.RemoteAddr = remote
+		generateDeduped(dedupKey(r, req), create)
+	}
+	if calls != 2 {
+		t.Errorf("socket Unix sem XFF: %d criações, esperado 2", calls)
+	}
+
+	calls = 0
+	var ids []int64
+	for _, remote := range []string{"203.0.113.7:1", "203.0.113.7:2", "198.51.100.1:1"} {
+		r := httptest.NewRequest("POST", "/api/generate", nil)
+		r.RemoteAddr = remote
+		id, _ := generateDeduped(dedupKey(r, req), create)
+		ids = append(ids, id)
+	}
+	if calls != 2 || ids[0] != ids[1] || ids[2] == ids[0] {
+		t.Errorf("ids %v com %d criações: esperado o mesmo id para o mesmo IP e outro para o segundo cliente", ids, calls)
+	}
+}
@@ -0,0 +1,87 @@
+//go:build loadgen
+
+// loadgen mede quantos aliases por segundo um servidor em execução consegue
+// criar. Aponte o servidor para uma Cloudflare falsa (CF_API_BASE_URL) ou use
+// CF_DRY_RUN=true, para não criar regras de verdade:
+//
+//	go run -tags loadgen ./cmd/loadgen -url http://localhost:8086 -n 1000 -c 16
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func main() {
+	base := flag.String("url", "http://localhost:8086", "endereço do servidor")
+	total := flag.Int("n", 500, "total de criações")
+	concurrency := flag.Int("c", 8, "requisições simultâneas")
+	ttl := flag.String("ttl", "10m", "ttl dos aliases criados")
+	token := flag.String("token", os.Getenv("APP_AUTH_TOKEN"), "APP_AUTH_TOKEN do servidor, se houver")
+	flag.Parse()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	body := fmt.Sprintf(`{"ttl":%q}`, *ttl)
+
+	var next, failed atomic.Int64
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	latencies := make([]time.Duration, 0, *total)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(*total) {
+				req, _ := http.NewRequest(http.MethodPost, strings.TrimRight(*base, "/")+"/api/v1/generate", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				if *token != "" {
+					req.Header.Set("Authorization", "Bearer "+*token)
+				}
+				t0 := time.Now()
+				resp, err := client.Do(req)
+				elapsed := time.Since(t0)
+				code := 0
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					code = resp.StatusCode
+				}
+				if code != http.StatusCreated {
+					failed.Add(1)
+				}
+				mu.Lock()
+				statuses[code]++
+				latencies = append(latencies, elapsed)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	duration := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	ok := int64(*total) - failed.Load()
+	fmt.Printf("%d criações em %s (%d simultâneas): %.1f aliases/s\n", ok, duration.Round(time.Millisecond), *concurrency, float64(ok)/duration.Seconds())
+	fmt.Printf("latência p50=%s p95=%s p99=%s\n", pct(0.50).Round(time.Millisecond), pct(0.95).Round(time.Millisecond), pct(0.99).Round(time.Millisecond))
+	fmt.Printf("status HTTP (0 = erro de rede): %v\n", statuses)
+	if failed.Load() > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Configurações usadas pelos handlers e workers. initRuntimeConfig as lê do
+// ambiente uma única vez, na partida, e o código consulta só estas variáveis:
+// um valor inválido encerra o processo ao subir, e não no meio de uma requisição.
+var (
+	cleanupConcurrency  = 5   // CLEANUP_CONCURRENCY: remoções simultâneas na limpeza
+	maxStatusIDs        = 100 // MAX_STATUS_IDS: ids por consulta em /api/emails/status
+	maxBulkIDs          = 100 // MAX_BULK_IDS: ids por operação em /api/bulk
+	cfMaxAttempts       = 3   // CF_MAX_ATTEMPTS: tentativas por chamada à Cloudflare
+	uiRefreshSeconds    = 30  // UI_REFRESH_SECONDS: 0 desabilita a atualização automática
+	allowGetMutations   bool  // ALLOW_GET_MUTATIONS: aceita GET nas rotas de alteração
+	requireDeleteReason bool  // REQUIRE_DELETE_REASON: exclusão exige motivo
+	authProtectUI       bool  // AUTH_PROTECT_UI: exige APP_AUTH_TOKEN também na interface
+	destinationVerify   bool  // DESTINATION_VERIFY: só aceita destinos verificados
+	healthzCheckCF      bool  // HEALTHZ_CHECK_CF: /healthz consulta a Cloudflare
+
+	cfStatsCacheTTL = 5 * time.Minute // CF_STATS_CACHE_TTL: cache de /api/cf-stats (0 desliga)
+	dedupWindow     time.Duration     // DEDUP_WINDOW: janela de deduplicação de generates (0 desliga)
+)
+
+func initRuntimeConfig() {
+	for key, target := range map[string]*int{
+		"CLEANUP_CONCURRENCY": &cleanupConcurrency,
+		"MAX_STATUS_IDS":      &maxStatusIDs,
+		"MAX_BULK_IDS":        &maxBulkIDs,
+		"CF_MAX_ATTEMPTS":     &cfMaxAttempts,
+	} {
+		if *target = envInt(key, *target); *target < 1 {
+			fatal(key+" deve ser pelo menos 1", "value", *target)
+		}
+	}
+	if uiRefreshSeconds = envInt("UI_REFRESH_SECONDS", uiRefreshSeconds); uiRefreshSeconds < 0 {
+		fatal("UI_REFRESH_SECONDS não pode ser negativo", "value", uiRefreshSeconds)
+	}
+
+	allowGetMutations = envBool("ALLOW_GET_MUTATIONS", false)
+	requireDeleteReason = envBool("REQUIRE_DELETE_REASON", false)
+	authProtectUI = envBool("AUTH_PROTECT_UI", false)
+	destinationVerify = envBool("DESTINATION_VERIFY", false)
+	healthzCheckCF = envBool("HEALTHZ_CHECK_CF", false)
+
+	for key, target := range map[string]*time.Duration{
+		"CF_STATS_CACHE_TTL": &cfStatsCacheTTL,
+		"DEDUP_WINDOW":       &dedupWindow,
+	} {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				fatal(key+" inválido", "value", v)
+			}
+			*target = d
+		}
+	}
+}
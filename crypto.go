@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Criptografia de colunas com dados pessoais: com DATA_ENCRYPTION_KEY (32 bytes
+// em hex ou base64) o destino dos aliases e o remetente/assunto da caixa de
+// entrada são gravados com AES-256-GCM e decifrados na leitura, o que protege
+// esses dados se o arquivo do banco vazar. O alias e o to_address ficam em
+// claro porque as consultas casam por eles. Sem a chave tudo é gravado em
+// claro, como antes; valores em claro já gravados continuam legíveis e são
+// cifrados na partida quando a chave é configurada.
+
+// encPrefix marca um valor cifrado: "enc:v1:" + base64(nonce || ciphertext)
+const encPrefix = "enc:v1:"
+
+var dataCipher cipher.AEAD
+
+var errNoDataKey = errors.New("valor cifrado no banco, mas DATA_ENCRYPTION_KEY não está configurado")
+
+func initDataEncryption() {
+	raw := strings.TrimSpace(os.Getenv("DATA_ENCRYPTION_KEY"))
+	if raw == "" {
+		return
+	}
+	key, err := hex.DecodeString(raw)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(raw)
+	}
+	if err != nil || len(key) != 32 {
+		fatal("DATA_ENCRYPTION_KEY deve ter 32 bytes em hex ou base64 (ex.: openssl rand -hex 32)")
+	}
+	block, _ := aes.NewCipher(key)
+	dataCipher, _ = cipher.NewGCM(block)
+	slog.Info("Criptografia de colunas sensíveis habilitada")
+}
+
+// sealField cifra o valor quando há chave; vazio continua vazio
+func sealField(plain string) string {
+	if dataCipher == nil || plain == "" {
+		return plain
+	}
+	nonce := make([]byte, dataCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("crypto/rand indisponível: %v", err))
+	}
+	return encPrefix + base64.StdEncoding.EncodeToString(dataCipher.Seal(nonce, nonce, []byte(plain), nil))
+}
+
+// openField decifra um valor gravado por sealField; valores em claro (sem o
+// prefixo) voltam como estão
+func openField(stored string) (string, error) {
+	data, ok := strings.CutPrefix(stored, encPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if dataCipher == nil {
+		return "", errNoDataKey
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) < dataCipher.NonceSize() {
+		return "", fmt.Errorf("valor cifrado corrompido")
+	}
+	nonce, sealed := raw[:dataCipher.NonceSize()], raw[dataCipher.NonceSize():]
+	plain, err := dataCipher.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("não foi possível decifrar o valor (DATA_ENCRYPTION_KEY diferente da usada na gravação?)")
+	}
+	return string(plain), nil
+}
+
+// encryptedColumns são as colunas cifradas, por tabela
+var encryptedColumns = map[string][]string{
+	"emails":         {"destination"},
+	"inbox_messages": {"from_address", "subject"},
+}
+
+// encryptExistingRows cifra os valores ainda em claro, gravados antes da chave
+// ser configurada. Roda na partida, depois de initDB.
+func encryptExistingRows() {
+	if dataCipher == nil {
+		return
+	}
+	for table, columns := range encryptedColumns {
+		for _, column := range columns {
+			n, err := encryptColumn(table, column)
+			if err != nil {
+				fatal("Erro ao cifrar dados existentes", "table", table, "column", column, "error", err)
+			}
+			if n > 0 {
+				slog.Info("Valores em claro cifrados", "table", table, "column", column, "rows", n)
+			}
+		}
+	}
+}
+
+func encryptColumn(table, column string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT id, %s FROM %s WHERE IFNULL(%s, '') != '' AND %s NOT LIKE '%s%%'", column, table, column, column, encPrefix))
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		id    int64
+		value string
+	}
+	var pending []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range pending {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", table, column), sealField(r.value), r.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(pending), tx.Commit()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useDataKey(t *testing.T, key string) {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := dataCipher
+	dataCipher, _ = cipher.NewGCM(block)
+	t.Cleanup(func() { dataCipher = old })
+}
+
+// Com a chave, destino e remetente/assunto ficam cifrados no banco e voltam em
+// claro pela aplicação; linhas antigas em claro são cifradas na partida
+func TestSensitiveColumnsEncryptedAtRest(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+
+	// Gravado antes de configurar a chave
+	legacy, err := createEmail(ctx, GenerateRequest{Prefix: "antigo", Destination: "antigo@d.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useDataKey(t, strings.Repeat("k", 32))
+	encryptExistingRows()
+
+	id, err := createEmail(ctx, GenerateRequest{Prefix: "novo", Destination: "pessoa@d.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row, want := range map[int64]string{legacy: "antigo@d.com", id: "pessoa@d.com"} {
+		var raw string
+		if err := db.QueryRow("SELECT destination FROM emails WHERE id = ?", row).Scan(&raw); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(raw, encPrefix) || strings.Contains(raw, want) {
+			t.Errorf("linha %d: destino gravado como %q", row, raw)
+		}
+		entry, err := getEmail(strconv.FormatInt(row, 10))
+		if err != nil || entry.Destination != want {
+			t.Errorf("linha %d: destino lido %q (erro %v), esperado %q", row, entry.Destination, err, want)
+		}
+	}
+
+	msg := InboxMessage{To: "novo@x.com", From: "remetente@y.com", Subject: "senha do banco", ReceivedAt: time.Now()}
+	if err := inbox.Store(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	var rawFrom, rawSubject string
+	if err := db.QueryRow("SELECT from_address, subject FROM inbox_messages").Scan(&rawFrom, &rawSubject); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(rawFrom, "remetente") || strings.Contains(rawSubject, "senha") {
+		t.Errorf("mensagem gravada em claro: from %q, subject %q", rawFrom, rawSubject)
+	}
+	got, err := inbox.Recent(ctx, "novo@x.com", time.Now().Add(-time.Hour), 10)
+	if err != nil || len(got) != 1 || got[0].From != msg.From || got[0].Subject != msg.Subject {
+		t.Errorf("mensagens lidas %+v (erro %v)", got, err)
+	}
+
+	// Sem a chave (ou com outra) o valor cifrado não é devolvido como se fosse o destino
+	dataCipher = nil
+	if _, err := getEmail(strconv.FormatInt(id, 10)); !errors.Is(err, errNoDataKey) {
+		t.Errorf("leitura sem chave: erro %v, esperado errNoDataKey", err)
+	}
+	useDataKey(t, strings.Repeat("z", 32))
+	if _, err := getEmail(strconv.FormatInt(id, 10)); err == nil {
+		t.Error("leitura com outra chave: esperado erro")
+	}
+}
+
+func TestPlaintextWithoutDataKey(t *testing.T) {
+	setupTest(t)
+	id, err := createEmail(context.Background(), GenerateRequest{Prefix: "claro", Destination: "claro@d.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw string
+	if err := db.QueryRow("SELECT destination FROM emails WHERE id = ?", id).Scan(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw != "claro@d.com" {
+		t.Errorf("destino gravado como %q sem DATA_ENCRYPTION_KEY", raw)
+	}
+}
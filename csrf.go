@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Proteção CSRF por double-submit cookie: a página inicial grava um token
+// aleatório no cookie csrf_token e o repete em cada formulário. Requisições
+// que alteram estado precisam mandar o mesmo valor no campo csrf_token (ou no
+// cabeçalho X-CSRF-Token). Ficam de fora os clientes que um navegador não
+// consegue forjar a partir de outro site: Authorization Bearer, corpo JSON e
+// renovações com token de renovação válido. CSRF_PROTECTION=false desativa.
+
+const csrfCookieName = "csrf_token"
+
+// csrfToken devolve o token do cookie, criando um novo quando não existe
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand indisponível: %v", err))
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// csrfExempt identifica requisições que não vêm de um formulário do navegador
+func csrfExempt(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+	// Navegadores só enviam JSON entre sites após um preflight CORS, que não liberamos
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		return true
+	}
+	if r.URL.Path == "/api/renew" {
+		return validRenewToken(r.FormValue("token"), r.FormValue("id"))
+	}
+	return false
+}
+
+// validCSRF compara o token enviado com o do cookie em tempo constante
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	given := r.Header.Get("X-CSRF-Token")
+	if given == "" {
+		given = r.FormValue(csrfCookieName)
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(c.Value)) == 1
+}
+
+// csrfMiddleware rejeita com 403 mutações sem token válido
+func csrfMiddleware(next http.Handler) http.Handler {
+	if !envBool("CSRF_PROTECTION", true) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !csrfExempt(r) && !validCSRF(r) {
+			err := errorf(http.StatusForbidden, "token CSRF ausente ou inválido; recarregue a página")
+			if wantsJSON(r) {
+				writeJSONError(w, err)
+			} else {
+				writeError(w, err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Deduplicação de generates: pedidos idênticos do mesmo cliente dentro de
+// DEDUP_WINDOW (ex.: "5s") recebem o resultado do primeiro, inclusive quando
+// chegam enquanto ele ainda está em andamento. Desabilitada quando vazia ou 0;
+// lida uma vez na partida (config.go), e um valor inválido encerra o processo. O
+// cliente é o de clientIdentity (clientip.go); sem identidade por cliente a
+// requisição não é deduplicada, para um usuário nunca receber o alias de outro.
+
+type dedupEntry struct {
+	done chan struct{}
+	id   int64
+	err  error
+	at   time.Time
+}
+
+var (
+	dedupMu    sync.Mutex
+	dedupCache = map[string]*dedupEntry{}
+)
+
+// dedupKey identifica pedidos idênticos do mesmo cliente; vazio quando o
+// cliente não pode ser identificado
+func dedupKey(r *http.Request, req GenerateRequest) string {
+	client := clientIdentity(r)
+	if client == "" {
+		return ""
+	}
+	return client + "|" + req.key()
+}
+
+// generateDeduped cria o email ou devolve o resultado do pedido idêntico em
+// andamento/recente; key vazia sempre cria
+func generateDeduped(key string, create func() (int64, error)) (int64, error) {
+	window := dedupWindow
+	if window == 0 || key == "" {
+		return create()
+	}
+
+	dedupMu.Lock()
+	// Remove entradas antigas para o mapa não crescer indefinidamente
+	for k, e := range dedupCache {
+		select {
+		case <-e.done:
+			if time.Since(e.at) > window {
+				delete(dedupCache, k)
+			}
+		default:
+		}
+	}
+	if e, ok := dedupCache[key]; ok {
+		dedupMu.Unlock()
+		<-e.done
+		return e.id, e.err
+	}
+	e := &dedupEntry{done: make(chan struct{})}
+	dedupCache[key] = e
+	dedupMu.Unlock()
+
+	e.id, e.err = create()
+	e.at = time.Now()
+	close(e.done)
+	return e.id, e.err
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Eventos de ciclo de vida publicados em uma fila de mensagens para que outros
+// serviços reajam sem precisar consultar a API: no Redis (EVENTS_REDIS_URL) e/ou
+// em um webhook (WEBHOOK_URL). O stream do painel (/api/events) recebe sempre.
+
+type LifecycleEvent struct {
+	Event     string    `json:"event"` // created, renewed, toggled, expiring, expired, deleted, recreated
+	ID        int       `json:"id"`
+	Alias     string    `json:"alias,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type EventPublisher interface {
+	Publish(ev LifecycleEvent) error
+}
+
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(LifecycleEvent) error { return nil }
+
+var events EventPublisher = noopPublisher{}
+
+// multiPublisher entrega o evento a todos os destinos configurados
+type multiPublisher []EventPublisher
+
+func (m multiPublisher) Publish(ev LifecycleEvent) error {
+	var errs []error
+	for _, p := range m {
+		if err := p.Publish(ev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiPublisher) targets() []EventPublisher { return m }
+
+// publishTargets separa os destinos de um publisher composto, para quem precisa
+// saber qual deles aceitou o evento (o aviso de expiração)
+func publishTargets(p EventPublisher) []EventPublisher {
+	if group, ok := p.(interface{ targets() []EventPublisher }); ok {
+		return group.targets()
+	}
+	return []EventPublisher{p}
+}
+
+func initEvents() {
+	publishers := multiPublisher{liveEvents}
+	if p := newRedisPublisherFromEnv(); p != nil {
+		publishers = append(publishers, p)
+	}
+	if raw := os.Getenv("WEBHOOK_URL"); raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			fatal("WEBHOOK_URL inválido")
+		}
+		publishers = append(publishers, newWebhookPublisher(raw))
+		// Só o host: URLs de webhook costumam levar o token no caminho
+		slog.Info("Enviando eventos para o webhook", "host", u.Host)
+	}
+
+	if len(publishers) == 1 {
+		events = publishers[0]
+	} else {
+		events = publishers
+	}
+}
+
+func newRedisPublisherFromEnv() *redisPublisher {
+	raw := os.Getenv("EVENTS_REDIS_URL")
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		fatal("EVENTS_REDIS_URL inválido")
+	}
+	channel := os.Getenv("EVENTS_CHANNEL")
+	if channel == "" {
+		channel = "temp-mail.events"
+	}
+	password, _ := u.User.Password()
+
+	slog.Info("Publicando eventos no Redis", "host", u.Host, "channel", channel)
+	return &redisPublisher{addr: u.Host, password: password, channel: channel}
+}
+
+// publishEvent publica em background para não atrasar a resposta ao usuário
+func publishEvent(event string, id int, alias, status string) {
+	ev := LifecycleEvent{Event: event, ID: id, Alias: alias, Status: status, Timestamp: time.Now().UTC()}
+	go func() {
+		if err := events.Publish(ev); err != nil {
+			slog.Error("Erro ao publicar evento", "event", event, "id", id, "error", err)
+		}
+	}()
+}
+
+// redisPublisher fala o protocolo RESP diretamente (apenas AUTH e PUBLISH),
+// mantendo uma conexão aberta e reconectando quando ela cai.
+type redisPublisher struct {
+	addr     string
+	password string
+	channel  string
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func (p *redisPublisher) Publish(ev LifecycleEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := p.command("PUBLISH", p.channel, string(payload)); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (p *redisPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	p.conn = conn
+	p.rd = bufio.NewReader(conn)
+
+	if p.password != "" {
+		if _, err := p.command("AUTH", p.password); err != nil {
+			conn.Close()
+			p.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *redisPublisher) command(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	p.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := p.conn.Write([]byte(b.String())); err != nil {
+		return "", err
+	}
+
+	line, err := p.rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "-") {
+		return "", fmt.Errorf("redis: %s", line[1:])
+	}
+	return line, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Exportação do histórico completo, com os mesmos filtros da listagem (status,
+// q, tag, created_after...). As linhas são escritas à medida que saem do
+// cursor, sem montar a lista em memória, então funciona com históricos grandes.
+
+// exportRows valida os filtros e abre o cursor antes de qualquer byte da
+// resposta, para que esses erros ainda possam virar um status HTTP
+func exportRows(r *http.Request) (*sql.Rows, error) {
+	where, args, err := buildEmailFilters(r.URL.Query())
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "%v", err)
+	}
+	return db.QueryContext(r.Context(), "SELECT "+emailColumns+" FROM emails"+where+" ORDER BY id", args...)
+}
+
+// eachExported chama fn para cada linha; para quando fn falha (cliente desconectou)
+func eachExported(rows *sql.Rows, fn func(EmailEntry) error) {
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			slog.Error("Erro ao ler email", "error", err)
+			continue
+		}
+		if err := fn(e); err != nil {
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		// O status já foi enviado: resta registrar e truncar a resposta
+		slog.Error("Erro ao exportar emails", "error", err)
+	}
+}
+
+func setExportHeaders(w http.ResponseWriter, contentType, ext string) {
+	filename := "temp-mail-" + time.Now().UTC().Format("20060102-150405") + "." + ext
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+}
+
+// handleExportCSV responde com id,alias,rule_id,created_at,expires_at,status
+func handleExportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	rows, err := exportRows(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	setExportHeaders(w, "text/csv; charset=utf-8", "csv")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "alias", "rule_id", "created_at", "expires_at", "status"})
+	eachExported(rows, func(e EmailEntry) error {
+		cw.Write([]string{strconv.Itoa(e.ID), e.Alias, e.RuleID,
+			e.CreatedAt.UTC().Format(time.RFC3339), e.ExpiresAt.UTC().Format(time.RFC3339), e.Status})
+		return cw.Error()
+	})
+	cw.Flush()
+}
+
+// handleExportJSON responde com um array JSON das entradas completas
+func handleExportJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	rows, err := exportRows(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	setExportHeaders(w, "application/json", "json")
+	enc := json.NewEncoder(w)
+	sep := "["
+	eachExported(rows, func(e EmailEntry) error {
+		if _, err := w.Write([]byte(sep)); err != nil {
+			return err
+		}
+		sep = ","
+		return enc.Encode(e)
+	})
+	if sep == "[" {
+		w.Write([]byte(sep))
+	}
+	w.Write([]byte("]\n"))
+}
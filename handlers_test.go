@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// postForm chama o handler direto, com o formulário no corpo
+func postForm(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// emailTimes lê created_at, expires_at e ttl_seconds direto do banco
+func emailTimes(t testing.TB, id int64) (createdAt, expiresAt time.Time, ttlSeconds int) {
+	t.Helper()
+	if err := db.QueryRow("SELECT created_at, expires_at, ttl_seconds FROM emails WHERE id = ?", id).Scan(&createdAt, &expiresAt, &ttlSeconds); err != nil {
+		t.Fatalf("lendo email %d: %v", id, err)
+	}
+	return createdAt, expiresAt, ttlSeconds
+}
+
+// assertNear falha quando got está a mais de um segundo de want: o SQLite
+// guarda as datas recalculadas com precisão de segundos
+func assertNear(t testing.TB, what string, got, want time.Time) {
+	t.Helper()
+	if d := got.Sub(want); d < -time.Second || d > time.Second {
+		t.Errorf("%s = %s, esperado %s (diferença %s)", what, got, want, d)
+	}
+}
+
+// generateWithTTL cria um alias pelo handler do formulário e devolve o id
+func generateWithTTL(t testing.TB, prefix, ttl string) int64 {
+	t.Helper()
+	w := postForm(handleGenerate, url.Values{"prefix": {prefix}, "ttl": {ttl}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("generate: HTTP %d: %s", w.Code, w.Body)
+	}
+	var id int64
+	if err := db.QueryRow("SELECT id FROM emails WHERE alias = ?", prefix+"@x.com").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestGenerateStoresTTL(t *testing.T) {
+	setupTest(t)
+
+	id := generateWithTTL(t, "quinze", "15m")
+	createdAt, expiresAt, ttlSeconds := emailTimes(t, id)
+	if ttlSeconds != 900 {
+		t.Errorf("ttl_seconds = %d, esperado 900", ttlSeconds)
+	}
+	assertNear(t, "expires_at", expiresAt, createdAt.Add(15*time.Minute))
+}
+
+func TestRenewUsesOriginalTTL(t *testing.T) {
+	setupTest(t)
+	id := generateWithTTL(t, "renova", "15m")
+	_, before, _ := emailTimes(t, id)
+
+	w := postForm(handleRenew, url.Values{"id": {fmt.Sprint(id)}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("renew: HTTP %d: %s", w.Code, w.Body)
+	}
+	_, after, _ := emailTimes(t, id)
+	assertNear(t, "expires_at depois de renovar", after, before.Add(15*time.Minute))
+
+	if w := postForm(handleRenew, url.Values{"id": {"999"}}); w.Code != http.StatusNotFound {
+		t.Errorf("renew de id inexistente: HTTP %d, esperado 404", w.Code)
+	}
+	if w := postForm(handleRenew, url.Values{"id": {"abc"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("renew com id inválido: HTTP %d, esperado 400", w.Code)
+	}
+}
+
+func TestRecreateResetsToOriginalTTL(t *testing.T) {
+	cf := setupTest(t)
+	id := generateWithTTL(t, "recria", "15m")
+	_, oldRule := emailStatus(t, id)
+
+	// Simula a expiração: sem regra e com expires_at no passado
+	if _, err := db.Exec("UPDATE emails SET status = 'expired', rule_id = '', expires_at = ? WHERE id = ?", time.Now().Add(-time.Hour), id); err != nil {
+		t.Fatal(err)
+	}
+	cf.mu.Lock()
+	delete(cf.rules, oldRule)
+	cf.mu.Unlock()
+
+	w := postForm(handleRecreate, url.Values{"id": {fmt.Sprint(id)}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("recreate: HTTP %d: %s", w.Code, w.Body)
+	}
+	status, ruleID := emailStatus(t, id)
+	if status != "active" {
+		t.Errorf("status %q depois de recriar, esperado active", status)
+	}
+	if _, ok := cf.rule(ruleID); !ok {
+		t.Errorf("regra %q não foi criada na Cloudflare", ruleID)
+	}
+	_, expiresAt, _ := emailTimes(t, id)
+	assertNear(t, "expires_at depois de recriar", expiresAt, time.Now().Add(15*time.Minute))
+
+	if w := postForm(handleRecreate, url.Values{"id": {"999"}}); w.Code != http.StatusNotFound {
+		t.Errorf("recreate de id inexistente: HTTP %d, esperado 404", w.Code)
+	}
+	if w := postForm(handleRecreate, url.Values{"id": {"-1"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("recreate com id inválido: HTTP %d, esperado 400", w.Code)
+	}
+}
+
+// Os quatro handlers que recebem id respondem 400 para id inválido, 404 para
+// id inexistente e redirecionam para a UI no sucesso
+func TestIDHandlers(t *testing.T) {
+	cf := setupTest(t)
+
+	handlers := []struct {
+		name    string
+		handler http.HandlerFunc
+		// prepare deixa o email no estado que a operação aceita
+		prepare func(t *testing.T, id int64)
+		check   func(t *testing.T, id int64)
+	}{
+		{"toggle", handleToggle, nil, func(t *testing.T, id int64) {
+			if status, _ := emailStatus(t, id); status != "inactive" {
+				t.Errorf("status %q depois de pausar, esperado inactive", status)
+			}
+		}},
+		{"delete", handleDelete, nil, func(t *testing.T, id int64) {
+			if status, ruleID := emailStatus(t, id); status != "deleted" || ruleID != "" {
+				t.Errorf("status %q, rule_id %q depois de apagar", status, ruleID)
+			}
+		}},
+		{"recreate", handleRecreate, func(t *testing.T, id int64) {
+			_, ruleID := emailStatus(t, id)
+			if _, err := db.Exec("UPDATE emails SET status = 'expired', rule_id = '' WHERE id = ?", id); err != nil {
+				t.Fatal(err)
+			}
+			cf.mu.Lock()
+			delete(cf.rules, ruleID)
+			cf.mu.Unlock()
+		}, func(t *testing.T, id int64) {
+			if status, ruleID := emailStatus(t, id); status != "active" || ruleID == "" {
+				t.Errorf("status %q, rule_id %q depois de recriar", status, ruleID)
+			}
+		}},
+		{"renew", handleRenew, nil, func(t *testing.T, id int64) {
+			if status, _ := emailStatus(t, id); status != "active" {
+				t.Errorf("status %q depois de renovar, esperado active", status)
+			}
+		}},
+	}
+
+	for _, h := range handlers {
+		t.Run(h.name, func(t *testing.T) {
+			for _, bad := range []string{"", "abc", "0", "-3", "1.5", "1 OR 1=1"} {
+				if w := postForm(h.handler, url.Values{"id": {bad}}); w.Code != http.StatusBadRequest {
+					t.Errorf("id %q: HTTP %d, esperado 400", bad, w.Code)
+				}
+			}
+			if w := postForm(h.handler, url.Values{"id": {"999999"}}); w.Code != http.StatusNotFound {
+				t.Errorf("id inexistente: HTTP %d, esperado 404", w.Code)
+			}
+
+			id := generateWithTTL(t, "id-"+h.name, "")
+			if h.prepare != nil {
+				h.prepare(t, id)
+			}
+			w := postForm(h.handler, url.Values{"id": {fmt.Sprint(id)}})
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+				t.Fatalf("HTTP %d (Location %q): %s", w.Code, w.Header().Get("Location"), w.Body)
+			}
+			h.check(t, id)
+		})
+	}
+}
+
+// A renovação em lote segue as regras da individual e publica um "renewed"
+// por alias renovado
+func TestRenewAllPublishesAndSharesTierRules(t *testing.T) {
+	setupTest(t)
+	pub := &recordingPublisher{}
+	testEvents.use(t, pub)
+
+	// Inseridos direto para não gerar eventos "created" em background
+	now := time.Now()
+	insert := func(alias, status, tier string, expiresAt time.Time, ttlSeconds int) int64 {
+		t.Helper()
+		res, err := db.Exec("INSERT INTO emails (alias, rule_id, status, tier, expires_at, ttl_seconds) VALUES (?, 'r', ?, ?, ?, ?)",
+			alias, status, tier, expiresAt, ttlSeconds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	curto := insert("curto@x.com", "active", "", now.Add(10*time.Minute), 900)
+	longo := insert("longo@x.com", "active", "", now.Add(time.Hour), 3600)
+	// Passaria do MAX_TTL; o tier que não existe mais cai no mesmo limite
+	insert("limite@x.com", "active", "", now.Add(maxTTL-time.Minute), 3600)
+	insert("sumiu@x.com", "active", "gone", now.Add(maxTTL-time.Minute), 3600)
+	insert("pausado@x.com", "inactive", "", now.Add(time.Hour), 3600)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/renew-all", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handleRenewAll(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"renewed":2`) || !strings.Contains(w.Body.String(), `"skipped":2`) {
+		t.Fatalf("renew-all: HTTP %d: %s", w.Code, w.Body)
+	}
+
+	_, exp, _ := emailTimes(t, curto)
+	assertNear(t, "expires_at de curto", exp, now.Add(25*time.Minute))
+	_, exp, _ = emailTimes(t, longo)
+	assertNear(t, "expires_at de longo", exp, now.Add(2*time.Hour))
+
+	// Mesma regra da renovação individual para o tier que saiu da configuração
+	var sumiu int64
+	db.QueryRow("SELECT id FROM emails WHERE alias = 'sumiu@x.com'").Scan(&sumiu)
+	if w := postForm(handleRenew, url.Values{"id": {fmt.Sprint(sumiu)}}); w.Code != http.StatusBadRequest {
+		t.Errorf("renew do tier removido: HTTP %d, esperado 400", w.Code)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pub.mu.Lock()
+		got := map[string]int{}
+		for _, ev := range pub.accepted {
+			if ev.Event == "renewed" {
+				got[ev.Alias]++
+			}
+		}
+		pub.mu.Unlock()
+		if len(got) == 2 && got["curto@x.com"] == 1 && got["longo@x.com"] == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("eventos renewed = %v, esperado um para curto@ e um para longo@", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestForceExpire(t *testing.T) {
+	cf := setupTest(t)
+	id := generateWithTTL(t, "forca", "1h")
+	_, ruleID := emailStatus(t, id)
+	before := testutil.ToFloat64(aliasesExpired)
+
+	w := postForm(handleForceExpire, url.Values{"id": {fmt.Sprint(id)}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expire: HTTP %d: %s", w.Code, w.Body)
+	}
+	if status, rule := emailStatus(t, id); status != "expired" || rule != "" {
+		t.Errorf("status %q, rule_id %q depois de expirar", status, rule)
+	}
+	if _, ok := cf.rule(ruleID); ok {
+		t.Errorf("regra %q continua na Cloudflare", ruleID)
+	}
+	if got := testutil.ToFloat64(aliasesExpired) - before; got != 1 {
+		t.Errorf("aliases_expired_total aumentou %v, esperado 1", got)
+	}
+
+	if w := postForm(handleForceExpire, url.Values{"id": {fmt.Sprint(id)}}); w.Code != http.StatusConflict {
+		t.Errorf("expire de email já expirado: HTTP %d, esperado 409", w.Code)
+	}
+	if w := postForm(handleForceExpire, url.Values{"id": {"999"}}); w.Code != http.StatusNotFound {
+		t.Errorf("expire de id inexistente: HTTP %d, esperado 404", w.Code)
+	}
+	if got := testutil.ToFloat64(aliasesExpired) - before; got != 1 {
+		t.Errorf("aliases_expired_total aumentou %v nas falhas, esperado 1", got)
+	}
+}
+
+// O purge remove as linhas expiradas além das deletadas, mas nunca uma com
+// regra ainda registrada
+func TestPurgeIncludesExpired(t *testing.T) {
+	setupTest(t)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, row := range []struct {
+		alias, status, rule string
+	}{
+		{"apagado@x.com", "deleted", ""},
+		{"vencido@x.com", "expired", ""},
+		{"comregra@x.com", "expired", "r1"},
+		{"ativo@x.com", "active", "r2"},
+	} {
+		if _, err := db.Exec("INSERT INTO emails (alias, rule_id, status, expires_at) VALUES (?, ?, ?, ?)", row.alias, row.rule, row.status, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO emails (alias, rule_id, status, expires_at) VALUES ('recente@x.com', '', 'expired', ?)", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := purgeOld(24 * time.Hour)
+	if err != nil || n != 2 {
+		t.Fatalf("purge removeu %d (erro %v), esperado 2", n, err)
+	}
+	var left int
+	db.QueryRow("SELECT COUNT(*) FROM emails WHERE alias IN ('comregra@x.com', 'ativo@x.com', 'recente@x.com')").Scan(&left)
+	if left != 3 {
+		t.Errorf("%d das 3 linhas que deviam ficar continuam no banco", left)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chaves de idempotência no generate: um cliente que repete o pedido com o
+// mesmo cabeçalho Idempotency-Key dentro de IDEMPOTENCY_TTL (padrão 24h)
+// recebe o email criado na primeira vez, sem nova regra na Cloudflare. A chave
+// fica gravada na linha criada; tentativas que falharam não guardam a chave,
+// então repetir depois de um erro tenta de novo.
+
+const maxIdempotencyKeyLength = 255
+
+var idempotencyTTL = 24 * time.Hour
+
+var (
+	idempotencyMu       sync.Mutex
+	idempotencyInFlight = map[string]chan struct{}{}
+)
+
+func initIdempotency() {
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := parseTTL(v)
+		if err != nil {
+			fatal("IDEMPOTENCY_TTL inválido", "error", err)
+		}
+		idempotencyTTL = d
+	}
+}
+
+// idempotencyKey lê e valida o cabeçalho; vazio quando ausente
+func idempotencyKey(r *http.Request) (string, error) {
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(key) > maxIdempotencyKeyLength {
+		return "", errorf(http.StatusBadRequest, "Idempotency-Key deve ter no máximo %d caracteres", maxIdempotencyKeyLength)
+	}
+	return key, nil
+}
+
+// findIdempotent procura o email criado com a chave dentro do TTL
+func findIdempotent(key string) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT id FROM emails WHERE idempotency_key = ? AND status != 'failed' AND datetime(created_at) >= datetime(?) ORDER BY id DESC LIMIT 1",
+		key, time.Now().Add(-idempotencyTTL).UTC().Format("2006-01-02 15:04:05")).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// generateIdempotent devolve o email já criado com a chave (replayed=true) ou
+// chama create. Pedidos simultâneos com a mesma chave esperam o primeiro
+// terminar e então repetem a busca, para não criarem dois emails.
+func generateIdempotent(key string, create func() (int64, error)) (id int64, replayed bool, err error) {
+	if key == "" {
+		id, err = create()
+		return id, false, err
+	}
+
+	for {
+		idempotencyMu.Lock()
+		wait, busy := idempotencyInFlight[key]
+		if !busy {
+			idempotencyInFlight[key] = make(chan struct{})
+		}
+		idempotencyMu.Unlock()
+		if !busy {
+			break
+		}
+		<-wait
+	}
+	defer func() {
+		idempotencyMu.Lock()
+		close(idempotencyInFlight[key])
+		delete(idempotencyInFlight, key)
+		idempotencyMu.Unlock()
+	}()
+
+	if id, err := findIdempotent(key); err != nil || id != 0 {
+		return id, id != 0, err
+	}
+	id, err = create()
+	return id, false, err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Importação de regras já existentes na Cloudflare: regras de um único
+// matcher literal "to" com ação forward passam a ser gerenciadas aqui. Elas
+// não tinham prazo, então recebem uma expiração distante em vez de TTL e
+// ganham a tag "imported" para serem encontradas na listagem.
+
+// importedExpiry é a expiração das regras importadas: longe o bastante para o
+// worker nunca removê-las, sem estourar a aritmética de datas do SQLite
+var importedExpiry = time.Date(2999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// ImportResult resume a importação
+type ImportResult struct {
+	Imported []ImportItem `json:"imported"`
+	Skipped  []ImportItem `json:"skipped"`
+}
+
+// ImportItem é uma regra importada (com o id da nova linha) ou ignorada (com o motivo)
+type ImportItem struct {
+	RuleID  string `json:"rule_id"`
+	Name    string `json:"name,omitempty"`
+	Alias   string `json:"alias,omitempty"`
+	EmailID int64  `json:"email_id,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// importCFRules lista as regras da zona e grava as que ainda não são acompanhadas
+func importCFRules(ctx context.Context) (ImportResult, error) {
+	result := ImportResult{Imported: []ImportItem{}, Skipped: []ImportItem{}}
+
+	rules, err := listCFRules(ctx)
+	if err != nil {
+		return result, &apiError{Status: 502, Message: "Erro ao listar regras na Cloudflare: " + err.Error(), Err: err}
+	}
+
+	for _, rule := range rules {
+		item := ImportItem{RuleID: rule.ID, Name: rule.Name}
+		alias, dests, reason := importableRule(rule)
+		if reason == "" {
+			item.Alias = alias
+			reason = importConflict(rule.ID, alias)
+		}
+		if reason != "" {
+			item.Reason = reason
+			result.Skipped = append(result.Skipped, item)
+			continue
+		}
+
+		status := "active"
+		if !rule.Enabled {
+			status = "inactive"
+		}
+		res, err := db.Exec("INSERT INTO emails (alias, rule_id, rule_tag, rule_priority, rule_enabled, destination, matcher, tags, status, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, 'imported', ?, ?)",
+			alias, rule.ID, rule.Tag, rule.Priority, rule.Enabled, sealField(strings.Join(dests, ",")), matcherLiteral, status, importedExpiry)
+		if isUniqueViolation(err) {
+			item.Reason = "alias já existe com outra regra"
+			result.Skipped = append(result.Skipped, item)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		item.EmailID, _ = res.LastInsertId()
+		result.Imported = append(result.Imported, item)
+		publishEvent("created", int(item.EmailID), alias, status)
+	}
+
+	slog.Info("Importação de regras da Cloudflare", "imported", len(result.Imported), "skipped", len(result.Skipped))
+	return result, nil
+}
+
+// importableRule extrai alias e destinos de uma regra simples; devolve o
+// motivo quando a regra não pode ser gerenciada
+func importableRule(rule CFRule) (alias string, dests []string, reason string) {
+	if len(rule.Matchers) != 1 || rule.Matchers[0].Type != "literal" || rule.Matchers[0].Field != "to" {
+		return "", nil, "matcher não é um literal \"to\""
+	}
+	for _, action := range rule.Actions {
+		if action.Type != "forward" {
+			return "", nil, "ação " + action.Type + " não é suportada"
+		}
+		dests = append(dests, action.Value...)
+	}
+	if len(dests) == 0 {
+		return "", nil, "regra sem destino"
+	}
+	return strings.ToLower(rule.Matchers[0].Value), dests, ""
+}
+
+// importConflict verifica se a regra ou o alias já são acompanhados
+func importConflict(ruleID, alias string) string {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM emails WHERE rule_id = ?", ruleID).Scan(&n); err == nil && n > 0 {
+		return "regra já acompanhada"
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM emails WHERE alias = ? AND status IN ('active', 'inactive')", alias).Scan(&n); err == nil && n > 0 {
+		return "alias já existe com outra regra"
+	}
+	return ""
+}
+
+// handleImport importa as regras existentes (POST) e devolve o resumo
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	result, err := importCFRules(r.Context())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Caixa de entrada: as regras só encaminham, então quem vê as mensagens é um
+// Email Worker da Cloudflare instalado junto (rota "Send to a Worker"). Ele
+// pode guardar os metadados de duas formas:
+//   - enviando cada mensagem para POST /api/inbox (JSON com to, from, subject,
+//     message_id, size e received_at), que grava na tabela inbox_messages;
+//   - guardando tudo do lado dele (KV, D1) e expondo uma API HTTP de consulta,
+//     configurada em INBOX_API_URL (GET ?to=<alias>&since=<RFC3339>&limit=<n>,
+//     com INBOX_API_TOKEN como Bearer), que devolve {"messages": [...]}.
+// GET /api/inbox?id= lista as mensagens recentes do alias. A associação é pelo
+// endereço "to" (em minúsculas); o catch-all "*@dominio" recebe as de todo o
+// domínio. Só entram mensagens recebidas depois da criação da linha, para um
+// alias reaproveitado não mostrar a correspondência do dono anterior.
+
+// InboxMessage são os metadados de uma mensagem recebida (o corpo não é guardado)
+type InboxMessage struct {
+	ID         int64     `json:"id,omitempty"`
+	To         string    `json:"to"`
+	From       string    `json:"from"`
+	Subject    string    `json:"subject"`
+	MessageID  string    `json:"message_id,omitempty"`
+	Size       int       `json:"size,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type InboxStore interface {
+	Store(ctx context.Context, msg InboxMessage) error
+	// Recent devolve as mensagens mais novas primeiro; alias "*@dominio" casa
+	// com qualquer endereço do domínio
+	Recent(ctx context.Context, alias string, since time.Time, limit int) ([]InboxMessage, error)
+	// Count conta as mensagens do alias recebidas desde since
+	Count(ctx context.Context, alias string, since time.Time) (int, error)
+}
+
+var inbox InboxStore = sqliteInbox{}
+
+var errInboxReadOnly = errors.New("a caixa de entrada é consultada em INBOX_API_URL; envie as mensagens para lá")
+
+const (
+	inboxDefaultLimit = 20
+	inboxMaxLimit     = 100
+)
+
+func initInbox() {
+	raw := os.Getenv("INBOX_API_URL")
+	if raw == "" {
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fatal("INBOX_API_URL inválida")
+	}
+	inbox = &httpInbox{url: raw, token: os.Getenv("INBOX_API_TOKEN"), client: &http.Client{Timeout: 10 * time.Second}}
+	slog.Info("Caixa de entrada consultada via HTTP", "host", u.Host)
+}
+
+// sqliteInbox guarda as mensagens na tabela inbox_messages do próprio banco
+type sqliteInbox struct{}
+
+func (sqliteInbox) Store(ctx context.Context, msg InboxMessage) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO inbox_messages (to_address, from_address, subject, message_id, size, received_at) VALUES (?, ?, ?, ?, ?, ?)",
+		msg.To, sealField(msg.From), sealField(msg.Subject), msg.MessageID, msg.Size, msg.ReceivedAt.UTC())
+	return err
+}
+
+// inboxMatch é a condição sobre to_address: igualdade no alias comum e final
+// "@dominio" no catch-all "*@dominio" (com o "@", para "*@x.com" não casar com
+// "a@evilx.com"). substr conta caracteres, daí o RuneCount.
+func inboxMatch(alias string) (string, []interface{}) {
+	if domain, ok := strings.CutPrefix(alias, "*@"); ok {
+		suffix := "@" + domain
+		return "substr(to_address, -?) = ?", []interface{}{utf8.RuneCountInString(suffix), suffix}
+	}
+	return "to_address = ?", []interface{}{alias}
+}
+
+// inboxWhere filtra as mensagens do alias recebidas desde since
+func inboxWhere(alias string, since time.Time) (string, []interface{}) {
+	match, args := inboxMatch(alias)
+	return "WHERE " + match + " AND datetime(received_at) >= datetime(?)", append(args, since.UTC().Format("2006-01-02 15:04:05"))
+}
+
+func (sqliteInbox) Recent(ctx context.Context, alias string, since time.Time, limit int) ([]InboxMessage, error) {
+	where, args := inboxWhere(alias, since)
+	rows, err := db.QueryContext(ctx, `SELECT id, to_address, IFNULL(from_address, ''), IFNULL(subject, ''), IFNULL(message_id, ''), IFNULL(size, 0), received_at
+		FROM inbox_messages `+where+`
+		ORDER BY received_at DESC, id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []InboxMessage{}
+	for rows.Next() {
+		var m InboxMessage
+		if err := rows.Scan(&m.ID, &m.To, &m.From, &m.Subject, &m.MessageID, &m.Size, &m.ReceivedAt); err != nil {
+			return nil, err
+		}
+		var err error
+		if m.From, err = openField(m.From); err != nil {
+			return nil, err
+		}
+		if m.Subject, err = openField(m.Subject); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (sqliteInbox) Count(ctx context.Context, alias string, since time.Time) (int, error) {
+	where, args := inboxWhere(alias, since)
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM inbox_messages "+where, args...).Scan(&n)
+	return n, err
+}
+
+// httpInbox consulta a API exposta pelo Worker
+type httpInbox struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (*httpInbox) Store(context.Context, InboxMessage) error { return errInboxReadOnly }
+
+func (h *httpInbox) Recent(ctx context.Context, alias string, since time.Time, limit int) ([]InboxMessage, error) {
+	q := url.Values{"to": {alias}, "since": {since.UTC().Format(time.RFC3339)}, "limit": {strconv.Itoa(limit)}}
+	sep := "?"
+	if strings.Contains(h.url, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url+sep+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("API da caixa de entrada respondeu HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Messages []InboxMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("resposta inválida da API da caixa de entrada: %v", err)
+	}
+	if body.Messages == nil {
+		body.Messages = []InboxMessage{}
+	}
+	if len(body.Messages) > limit {
+		body.Messages = body.Messages[:limit]
+	}
+	return body.Messages, nil
+}
+
+// Count usa a mesma consulta de Recent, então fica limitado a inboxMaxLimit
+func (h *httpInbox) Count(ctx context.Context, alias string, since time.Time) (int, error) {
+	messages, err := h.Recent(ctx, alias, since, inboxMaxLimit)
+	return len(messages), err
+}
+
+// handleInbox lista as mensagens de um alias (GET) ou recebe uma do Worker (POST)
+func handleInbox(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listInbox(w, r)
+	case http.MethodPost:
+		receiveInboxMessage(w, r)
+	default:
+		writeJSONError(w, errorf(405, "Method not allowed"))
+	}
+}
+
+func listInbox(w http.ResponseWriter, r *http.Request) {
+	id, err := formID(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	limit := inboxDefaultLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSONError(w, errorf(http.StatusBadRequest, "limit inválido: %q", v))
+			return
+		}
+		limit = min(n, inboxMaxLimit)
+	}
+
+	entry, err := getEmail(id)
+	if err == sql.ErrNoRows {
+		writeJSONError(w, errorf(http.StatusNotFound, "email não encontrado"))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	messages, err := inbox.Recent(r.Context(), strings.ToLower(entry.Alias), entry.CreatedAt, limit)
+	if err != nil {
+		writeJSONError(w, &apiError{Status: 502, Message: "Erro ao consultar a caixa de entrada: " + err.Error(), Err: err})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": entry.ID, "alias": entry.Alias, "messages": messages})
+}
+
+// receiveInboxMessage grava a mensagem enviada pelo Worker. Endereços que não
+// correspondem a um alias acompanhado são recusados, para a tabela não
+// acumular spam de endereços que nunca existiram aqui.
+func receiveInboxMessage(w http.ResponseWriter, r *http.Request) {
+	var msg InboxMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&msg); err != nil {
+		writeJSONError(w, errorf(http.StatusBadRequest, "JSON inválido: %v", err))
+		return
+	}
+	msg.To = strings.ToLower(strings.TrimSpace(msg.To))
+	at := strings.LastIndex(msg.To, "@")
+	if at < 1 {
+		writeJSONError(w, errorf(http.StatusBadRequest, "to inválido: %q", msg.To))
+		return
+	}
+	if msg.ReceivedAt.IsZero() {
+		msg.ReceivedAt = time.Now()
+	}
+
+	var n int
+	if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM emails WHERE alias IN (?, ?) AND status IN ('active', 'inactive')",
+		msg.To, "*"+msg.To[at:]).Scan(&n); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if n == 0 {
+		writeJSONError(w, errorf(http.StatusNotFound, "alias não acompanhado: %s", msg.To))
+		return
+	}
+
+	if err := inbox.Store(r.Context(), msg); err != nil {
+		if errors.Is(err, errInboxReadOnly) {
+			writeJSONError(w, errorf(http.StatusNotImplemented, "%v", err))
+			return
+		}
+		writeJSONError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Um alias só vê as próprias mensagens, mesmo quando outro termina com ele
+// (letter@ e newsletter@), e o catch-all só casa com o domínio exato
+func TestInboxMatchesWholeAddress(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	since := time.Now().Add(-time.Hour)
+
+	for _, to := range []string{"letter@x.com", "newsletter@x.com", "newsletter@x.com", "a@evilx.com"} {
+		if err := inbox.Store(ctx, InboxMessage{To: to, From: "f@y.com", Subject: "oi", ReceivedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for alias, want := range map[string]int{
+		"letter@x.com":     1,
+		"newsletter@x.com": 2,
+		"*@x.com":          3,
+		"*@evilx.com":      1,
+		"*@ilx.com":        0,
+	} {
+		msgs, err := inbox.Recent(ctx, alias, since, inboxMaxLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := inbox.Count(ctx, alias, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != want || n != want {
+			t.Errorf("%s: Recent %d, Count %d, esperado %d", alias, len(msgs), n, want)
+		}
+		for _, m := range msgs {
+			if alias[0] != '*' && m.To != alias {
+				t.Errorf("%s recebeu a mensagem de %s", alias, m.To)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Logs estruturados: JSON por padrão para o agregador, LOG_FORMAT=text para
+// desenvolvimento local. LOG_LEVEL aceita debug, info (padrão), warn e error.
+// Os campos seguem nomes fixos (alias, rule_id, id, event, error) para que
+// possam ser filtrados.
+
+func initLogger() {
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			fatal("LOG_LEVEL inválido", "value", v)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		fatal("LOG_FORMAT inválido (use json ou text)", "value", format)
+	}
+	// Também redireciona o pacote log, usado por bibliotecas, para o mesmo handler
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal registra o erro e encerra o processo, como log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// statusRecorder guarda o status escrito pelo handler; http.Error e
+// http.Redirect também passam por WriteHeader
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap deixa http.ResponseController alcançar o writer original (Flush etc.)
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logRequests registra método, caminho, status, duração e IP de cada
+// requisição. A query string fica de fora porque pode levar tokens de
+// renovação; /healthz e /metrics só aparecem em LOG_LEVEL=debug.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case r.URL.Path == "/healthz" || r.URL.Path == "/metrics":
+			level = slog.LevelDebug
+		}
+		slog.Log(r.Context(), level, "Requisição HTTP",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_ip", clientIP(r),
+		)
+	})
+}
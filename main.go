@@ -2,18 +2,31 @@ package main
 
 import (
 	"bytes"
+	"context"
+	crand "crypto/rand"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
-	"log"
+	"log/slog"
+	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Estruturas
@@ -24,6 +37,43 @@ type EmailEntry struct {
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"` // Novo campo
 	Status    string    `json:"status"`
+
+	// Metadados da regra na Cloudflare
+	RuleTag      string `json:"rule_tag"`
+	RulePriority int    `json:"rule_priority"`
+	RuleEnabled  bool   `json:"rule_enabled"`
+
+	Tier        string `json:"tier,omitempty"`
+	Destination string `json:"destination,omitempty"` // lista separada por vírgulas; vazio = CF_DESTINATION_EMAIL
+
+	TTLSeconds int  `json:"ttl_seconds,omitempty"` // TTL escolhido na criação
+	AutoRenew  bool `json:"auto_renew"`
+
+	Matcher   string `json:"matcher"`              // "literal" ou "all" (catch-all do domínio)
+	LastError string `json:"last_error,omitempty"` // erro da Cloudflare quando status = failed
+
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // preenchido quando status = deleted
+}
+
+// IndexData é o que o template da página inicial recebe
+type IndexData struct {
+	Emails         []EmailEntry
+	Page           Pagination
+	Query          string // busca atual (q)
+	Status         string // filtro de status atual
+	Tag            string // filtro de tag atual
+	TTLPresets     []TTLPreset
+	DefaultTTL     time.Duration // preset pré-selecionado no formulário
+	Domains        []string
+	RefreshSeconds int // 0 desabilita a atualização automática
+	Warnings       []string
+	CSRFToken      string // repetido em cada formulário (ver csrf.go)
+	Stats          *Stats // nil se as agregações falharem
+
+	RequireDeleteReason bool // REQUIRE_DELETE_REASON: o formulário de exclusão pede o motivo
 }
 
 type CFRequest struct {
@@ -35,60 +85,398 @@ type CFRequest struct {
 
 type CFMatcher struct {
 	Type  string `json:"type"`
-	Field string `json:"field"`
-	Value string `json:"value"`
+	Field string `json:"field,omitempty"`
+	Value string `json:"value,omitempty"`
 }
 
 type CFAction struct {
 	Type  string   `json:"type"`
-	Value []string `json:"value"`
+	Value []string `json:"value,omitempty"`
+}
+
+// CFRule é a regra de roteamento retornada pela Cloudflare
+type CFRule struct {
+	ID       string      `json:"id"`
+	Tag      string      `json:"tag"`
+	Name     string      `json:"name"`
+	Priority int         `json:"priority"`
+	Enabled  bool        `json:"enabled"`
+	Matchers []CFMatcher `json:"matchers,omitempty"`
+	Actions  []CFAction  `json:"actions,omitempty"`
+}
+
+// CFResultInfo traz os dados de paginação das listagens
+type CFResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+	TotalCount int `json:"total_count"`
 }
 
 type CFResponse struct {
-	Success bool `json:"success"`
-	Result  struct {
-		ID string `json:"id"`
-	} `json:"result"`
-	Errors []struct {
-		Message string `json:"message"`
-	} `json:"errors"`
+	Success    bool            `json:"success"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo CFResultInfo    `json:"result_info"`
+	Errors     []CFErrorDetail `json:"errors"`
+}
+
+type CFErrorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
 }
 
+// Códigos que a Cloudflare retorna para um zone ID inexistente ou inacessível
+var cfInvalidZoneCodes = map[int]bool{1001: true, 7003: true}
+
+// Versão da aplicação, usada no User-Agent das chamadas à Cloudflare
+const appVersion = "1.0.0"
+
+var userAgent = "temp-mail/" + appVersion + " (+https://github.com/robertocjunior/temp-mail)"
+
 var db *sql.DB
 
+// Prefixo (parte local) aceito para aliases
+var prefixPattern = regexp.MustCompile(`^[a-z0-9._-]{1,32}$`)
+
+// Partes locais típicas de caixas que descartam emails recebidos
+var noReplyPattern = regexp.MustCompile(`(?i)^(no-?reply|do-?not-?reply|mailer-daemon|postmaster|bounces?)([+._-].*)?@`)
+
+// Avisos de configuração detectados na inicialização
+var configWarnings []string
+
 func main() {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8086"
 	}
 
+	initLogger()
+	initCFErrors()
+	initRuntimeConfig()
+	initCFDryRun()
+	initCFClient()
+	validateConfig()
+	initDataEncryption()
 	initDB()
+	encryptExistingRows()
+	initNotify()
+	initEvents()
+	initInbox()
+	initTTLPresets()
+	initAliasGenerator()
+	initIdempotency()
+	initTiers()
+	initCatchAll()
+	initTrustedProxies()
+	initRateLimit()
+	checkNoReplyDestination()
+
+	// Autoteste opcional: falha cedo se a zona ou o token estiverem errados
+	if envBool("CF_STARTUP_CHECK", false) {
+		if err := checkCFZone(context.Background()); err != nil {
+			fatal("Autoteste da Cloudflare falhou", "error", err)
+		}
+		slog.Info("Autoteste da Cloudflare OK")
+	}
+
+	// Configura a ação catch-all da zona na inicialização, se pedido. Um alias
+	// catch-all ativo é dono da regra e não é sobrescrito.
+	if os.Getenv("CATCH_ALL_ACTION") != "" {
+		var active int
+		if err := db.QueryRow("SELECT COUNT(*) FROM emails WHERE matcher = ? AND status = 'active'", matcherAll).Scan(&active); err != nil {
+			fatal("Erro ao consultar alias catch-all", "error", err)
+		}
+		if active > 0 {
+			slog.Warn("CATCH_ALL_ACTION ignorado: há um alias catch-all ativo", "action", catchAllAction)
+		} else {
+			if _, err := restoreCatchAll(context.Background()); err != nil {
+				fatal("Erro ao configurar catch-all", "action", catchAllAction, "error", err)
+			}
+			slog.Info("Catch-all da zona configurado", "action", catchAllAction)
+		}
+	}
+
+	// SIGINT/SIGTERM cancelam o contexto: workers param e o servidor drena as requisições
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var workers sync.WaitGroup
+
+	// Inicia o worker de limpeza em background (CLEANUP_INTERVAL, padrão 1 minuto)
+	cleanupInterval := time.Minute
+	if v := os.Getenv("CLEANUP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			fatal("CLEANUP_INTERVAL inválido", "value", v)
+		}
+		cleanupInterval = d
+	}
+	if v := os.Getenv("PURGE_AFTER"); v != "" {
+		d, err := parseTTL(v)
+		if err != nil {
+			fatal("PURGE_AFTER inválido", "error", err)
+		}
+		purgeAfter = d
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		startCleanupWorker(ctx, cleanupInterval)
+	}()
+
+	// Ticks do stream ao vivo (SSE_TICK_INTERVAL, padrão 5s)
+	tickInterval := 5 * time.Second
+	if v := os.Getenv("SSE_TICK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			fatal("SSE_TICK_INTERVAL inválido", "value", v)
+		}
+		tickInterval = d
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		liveEvents.run(ctx, tickInterval)
+	}()
+
+	// SYNC_ON_STARTUP=true reconcilia uma vez ao subir (corrige com RECONCILE_FIX=true)
+	if envBool("SYNC_ON_STARTUP", false) {
+		go runReconcile(ctx, envBool("RECONCILE_FIX", false))
+	}
 
-	// Inicia o worker de limpeza em background
-	go startCleanupWorker()
+	if v := os.Getenv("RECONCILE_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			fatal("RECONCILE_INTERVAL inválido", "value", v)
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			startReconcileWorker(ctx, interval, envBool("RECONCILE_FIX", false))
+		}()
+	}
 
 	// Rotas
-	http.HandleFunc("/", handleIndex)
-	http.HandleFunc("/api/generate", handleGenerate)
+	if envBool("UI_ENABLED", true) {
+		initTemplates()
+		http.HandleFunc("/", handleIndex)
+	} else {
+		// Modo somente API: não serve nem carrega templates
+		http.HandleFunc("/", handleUIDisabled)
+	}
+	http.HandleFunc("/api/generate", rateLimited(handleGenerate))
+	http.HandleFunc("/api/v1/generate", rateLimited(handleAPIGenerate))
 	http.HandleFunc("/api/toggle", handleToggle)
 	http.HandleFunc("/api/delete", handleDelete)
 	http.HandleFunc("/api/recreate", handleRecreate)
+	http.HandleFunc("/api/bulk", handleBulk)
+	http.HandleFunc("/api/purge", handlePurge)
 	http.HandleFunc("/api/renew", handleRenew) // Nova rota
+	http.HandleFunc("/api/renew-all", handleRenewAll)
+	http.HandleFunc("/api/autorenew", handleAutoRenew)
+	http.HandleFunc("/api/tag", handleTag)
+	http.HandleFunc("/api/note", handleNote)
+	http.HandleFunc("/api/email", handleEmailByQuery)
+	http.HandleFunc("/api/email/", handleEmailRoutes)
+	http.HandleFunc("/api/validate", handleValidate)
+	http.HandleFunc("/api/search", handleSearch)
+	http.HandleFunc("/api/events", handleEvents)
+	http.HandleFunc("/api/list", handleList)
+	http.HandleFunc("/api/stats", handleStats)
+	http.HandleFunc("/api/qr", handleQR)
+	http.HandleFunc("/api/alias", handleAlias)
+	http.HandleFunc("/api/cf-stats", handleCFStats)
+	http.HandleFunc("/api/emails/status", handleEmailsStatus)
+	http.HandleFunc("/api/catch-all", handleCatchAll)
+	http.HandleFunc("/api/config", handleConfig)
+	http.HandleFunc("/api/admin/verify-destination", handleVerifyDestination)
+	http.HandleFunc("/api/active.txt", handleActiveTxt)
+	http.HandleFunc("/api/export.csv", handleExportCSV)
+	http.HandleFunc("/api/export.json", handleExportJSON)
+	http.HandleFunc("/api/backup", handleBackup)
+	http.HandleFunc("/api/restore", handleRestore)
+	http.HandleFunc("/api/expire", handleForceExpire)
+	http.HandleFunc("/api/admin/force-expire", handleForceExpire) // nome antigo, mantido por compatibilidade
+	http.HandleFunc("/api/reconcile", handleReconcile)
+	http.HandleFunc("/api/reconcile/status", handleReconcileStatus)
+	http.HandleFunc("/api/sync", handleSync)
+	http.HandleFunc("/api/import", handleImport)
+	http.HandleFunc("/api/inbox", handleInbox)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/api/admin/cf-errors", handleCFErrors)
+	http.HandleFunc("/healthz", handleHealthz)
+
+	listener, err := createListener(port)
+	if err != nil {
+		fatal("Erro ao abrir listener", "error", err)
+	}
+
+	// O log de acesso fica por fora para registrar também os 401 da autenticação
+	server := &http.Server{Handler: logRequests(authMiddleware(csrfMiddleware(http.DefaultServeMux)))}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fatal("Erro no servidor HTTP", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	timeout := 15 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			fatal("SHUTDOWN_TIMEOUT inválido", "value", v)
+		}
+	}
+	slog.Info("Encerrando: aguardando requisições em andamento", "timeout", timeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Erro ao encerrar servidor", "error", err)
+	}
+
+	// Espera o ciclo atual dos workers e os avisos em entrega antes de fechar o banco
+	workers.Wait()
+	expiringDeliveries.Wait()
+	db.Close()
+	slog.Info("Servidor encerrado")
+}
+
+// createListener abre um socket Unix quando LISTEN_SOCKET está definido
+// (ex.: nginx no mesmo host) e cai para TCP em :PORT caso contrário.
+func createListener(port string) (net.Listener, error) {
+	socketPath := os.Getenv("LISTEN_SOCKET")
+	if socketPath == "" {
+		slog.Info("Servidor rodando (Tabler UI)", "port", port)
+		return net.Listen("tcp", ":"+port)
+	}
+
+	// Remove socket antigo deixado por uma execução anterior
+	if info, err := os.Stat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s existe e não é um socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Permissão padrão 0660: dono e grupo (ex.: www-data do nginx)
+	mode := os.FileMode(0660)
+	if m := os.Getenv("LISTEN_SOCKET_MODE"); m != "" {
+		parsed, err := strconv.ParseUint(m, 8, 32)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("LISTEN_SOCKET_MODE inválido: %s", m)
+		}
+		mode = os.FileMode(parsed)
+	}
+	if err := os.Chmod(socketPath, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
 
-	log.Printf("Servidor rodando na porta %s (Tabler UI)...", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	slog.Info("Servidor rodando (Tabler UI)", "socket", socketPath)
+	return listener, nil
 }
 
 func initDB() {
-	var err error
 	dbPath := os.Getenv("DB_PATH")
 	if dbPath == "" {
 		dbPath = "./data/emails.db"
 	}
 
-	db, err = sql.Open("sqlite3", dbPath)
+	// Em containers o volume de dados pode ainda não estar montado na
+	// inicialização, então tentamos algumas vezes antes de desistir
+	attempts := envInt("DB_OPEN_RETRIES", 5)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := openDB(dbPath)
+		if err == nil {
+			break
+		}
+		if attempt >= attempts {
+			fatal("Erro ao abrir banco", "path", dbPath, "error", err)
+		}
+		slog.Warn("Erro ao abrir banco, nova tentativa", "attempt", attempt, "max_attempts", attempts, "backoff", backoff.String(), "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	// Migração simples: Tenta adicionar a coluna expires_at caso o banco já exista sem ela
+	// Ignora erro se a coluna já existir
+	db.Exec("ALTER TABLE emails ADD COLUMN expires_at DATETIME")
+	db.Exec("ALTER TABLE emails ADD COLUMN rule_tag TEXT")
+	db.Exec("ALTER TABLE emails ADD COLUMN rule_priority INTEGER")
+	db.Exec("ALTER TABLE emails ADD COLUMN rule_enabled BOOLEAN")
+	db.Exec("ALTER TABLE emails ADD COLUMN tier TEXT")
+	db.Exec("ALTER TABLE emails ADD COLUMN destination TEXT")
+	db.Exec("ALTER TABLE emails ADD COLUMN ttl_seconds INTEGER")
+	db.Exec("ALTER TABLE emails ADD COLUMN auto_renew BOOLEAN")
+	db.Exec("ALTER TABLE emails ADD COLUMN matcher TEXT")
+	db.Exec("ALTER TABLE emails ADD COLUMN last_error TEXT")
+	db.Exec("ALTER TABLE emails ADD COLUMN deleted_at DATETIME")
+	db.Exec("ALTER TABLE emails ADD COLUMN tags TEXT")
+	db.Exec("ALTER TABLE emails ADD COLUMN note TEXT")
+	db.Exec("ALTER TABLE emails ADD COLUMN notified BOOLEAN")
+	db.Exec("ALTER TABLE emails ADD COLUMN idempotency_key TEXT")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_emails_idempotency_key ON emails (idempotency_key) WHERE idempotency_key IS NOT NULL")
+
+	// Um alias só pode ter uma linha ativa (senão seriam duas regras para o
+	// mesmo endereço). Bancos que já têm duplicados seguem sem o índice até
+	// serem corrigidos, com um aviso listando os aliases.
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_emails_active_alias ON emails (alias) WHERE status = 'active'"); err != nil {
+		var dups []string
+		if rows, qErr := db.Query("SELECT alias FROM emails WHERE status = 'active' GROUP BY alias HAVING COUNT(*) > 1"); qErr == nil {
+			for rows.Next() {
+				var alias string
+				if rows.Scan(&alias) == nil {
+					dups = append(dups, alias)
+				}
+			}
+			rows.Close()
+		}
+		slog.Warn("Índice único de aliases ativos não criado; desative os duplicados e reinicie", "duplicates", dups, "error", err)
+	}
+}
+
+// sqliteDSN acrescenta ao caminho o modo de journal e o busy timeout
+func sqliteDSN(dbPath string) string {
+	// Vão na DSN e não em PRAGMA porque valem para cada conexão nova do pool:
+	// com WAL as leituras não bloqueiam a escrita, e o busy timeout faz uma
+	// escrita concorrente esperar a vez em vez de falhar com "database is locked"
+	journal := os.Getenv("DB_JOURNAL_MODE")
+	if journal == "" {
+		journal = "WAL"
+	}
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_journal_mode=%s&_busy_timeout=%d", dbPath, sep, journal, envInt("DB_BUSY_TIMEOUT_MS", 5000))
+}
+
+// openDB abre a conexão, confirma que o arquivo está acessível e cria a tabela
+func openDB(dbPath string) error {
+	var err error
+	db, err = sql.Open("sqlite3", sqliteDSN(dbPath))
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	// O SQLite serializa as escritas de qualquer forma; poucas conexões evitam
+	// disputa pelo lock. Não use 1: o código lê com um cursor aberto enquanto
+	// consulta outras tabelas, o que travaria esperando a própria conexão.
+	maxConns := envInt("DB_MAX_OPEN_CONNS", 8)
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return err
 	}
 
 	// Cria tabela se não existir
@@ -101,248 +489,2330 @@ func initDB() {
 		expires_at DATETIME,
 		status TEXT DEFAULT 'active'
 	);`
-	_, err = db.Exec(query)
+	if _, err = db.Exec(query); err != nil {
+		db.Close()
+		return err
+	}
+
+	// Registro de ações administrativas e seus motivos
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email_id INTEGER,
+		action TEXT NOT NULL,
+		reason TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`)
 	if err != nil {
-		log.Fatal(err)
+		db.Close()
+		return err
 	}
 
-	// Migração simples: Tenta adicionar a coluna expires_at caso o banco já exista sem ela
-	// Ignora erro se a coluna já existir
-	db.Exec("ALTER TABLE emails ADD COLUMN expires_at DATETIME")
+	// Metadados das mensagens recebidas, gravados pelo Email Worker (ver inbox.go)
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS inbox_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		to_address TEXT NOT NULL,
+		from_address TEXT,
+		subject TEXT,
+		message_id TEXT,
+		size INTEGER,
+		received_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_inbox_to ON inbox_messages (to_address, received_at);`)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	return nil
 }
 
-// --- WORKER DE LIMPEZA ---
-func startCleanupWorker() {
-	ticker := time.NewTicker(1 * time.Minute)
-	log.Println("Iniciando monitoramento de expiração de emails...")
-	for range ticker.C {
-		checkExpiredEmails()
+// isStorageError identifica disco cheio e erros de I/O do SQLite
+func isStorageError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrFull || sqliteErr.Code == sqlite3.ErrIoErr
 	}
+	return false
 }
 
-func checkExpiredEmails() {
-	// Busca emails ativos que já venceram
-	rows, err := db.Query("SELECT id, rule_id, alias FROM emails WHERE status = 'active' AND expires_at < datetime('now')")
-	if err != nil {
-		log.Println("Erro ao verificar expiração:", err)
-		return
+// isUniqueViolation identifica a violação de um índice UNIQUE, como o de
+// aliases ativos (idx_emails_active_alias)
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// recordAudit grava uma ação no audit_log; falhas só são logadas
+func recordAudit(emailID int, action, reason string) {
+	if _, err := db.Exec("INSERT INTO audit_log (email_id, action, reason) VALUES (?, ?, ?)", emailID, action, reason); err != nil {
+		slog.Error("Erro ao gravar audit_log", "action", action, "id", emailID, "error", err)
 	}
-	defer rows.Close()
+}
 
-	for rows.Next() {
-		var id int
-		var ruleID, alias string
-		if err := rows.Scan(&id, &ruleID, &alias); err != nil {
+// checkNoReplyDestination avisa quando o destino parece uma caixa que não
+// recebe emails: o serviço inteiro pareceria quebrado. Desative com NOREPLY_CHECK=false.
+func checkNoReplyDestination() {
+	if !envBool("NOREPLY_CHECK", true) {
+		return
+	}
+	for _, dest := range splitDestinations(os.Getenv("CF_DESTINATION_EMAIL")) {
+		if !noReplyPattern.MatchString(dest) {
 			continue
 		}
+		warning := fmt.Sprintf("CF_DESTINATION_EMAIL (%s) parece um endereço no-reply; emails encaminhados podem ser descartados", dest)
+		configWarnings = append(configWarnings, warning)
+		slog.Warn(warning)
+	}
+}
 
-		log.Printf("Expirando email automaticamente: %s", alias)
+// Variáveis sem as quais nenhuma regra pode ser criada
+var requiredEnv = []string{"CF_API_TOKEN", "CF_ZONE_ID", "CF_EMAIL_DOMAIN", "CF_DESTINATION_EMAIL"}
 
-		// Remove da Cloudflare
-		if ruleID != "" {
-			deleteCFRule(ruleID)
+// validateConfig falha na inicialização, listando tudo o que falta, em vez de
+// deixar o erro aparecer no meio de uma requisição. Também confere o token com
+// uma chamada à Cloudflare (desative com CF_VERIFY_TOKEN=false).
+func validateConfig() {
+	var problems []string
+	for _, key := range requiredEnv {
+		if cfDryRun && (key == "CF_API_TOKEN" || key == "CF_ZONE_ID") {
+			continue
+		}
+		if strings.TrimSpace(os.Getenv(key)) == "" {
+			problems = append(problems, key+" não definida")
 		}
+	}
+	for _, dest := range splitDestinations(os.Getenv("CF_DESTINATION_EMAIL")) {
+		if addr, err := mail.ParseAddress(dest); err != nil || addr.Address != dest {
+			problems = append(problems, "CF_DESTINATION_EMAIL contém um endereço inválido: "+dest)
+		}
+	}
+	if len(problems) > 0 {
+		fatal("Configuração inválida", "problems", problems)
+	}
 
-		// Marca como deletado no banco
-		db.Exec("UPDATE emails SET status = 'deleted', rule_id = '' WHERE id = ?", id)
+	if envBool("CF_VERIFY_TOKEN", true) && !cfDryRun {
+		if err := verifyCFToken(context.Background()); err != nil {
+			fatal("Configuração inválida: CF_API_TOKEN rejeitado pela Cloudflare", "error", err)
+		}
+		slog.Info("Token da Cloudflare verificado")
 	}
 }
 
-// --- HANDLERS ---
-
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/index.html")
+// envInt lê uma variável de ambiente inteira, usando o padrão quando ausente
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		fatal(key+" inválido", "value", v)
 	}
+	return n
+}
 
-	// Ordena por status (ativos primeiro) e depois por data
-	rows, err := db.Query(`
-		SELECT id, alias, rule_id, created_at, IFNULL(expires_at, created_at), status 
-		FROM emails 
-		ORDER BY CASE WHEN status='active' THEN 1 ELSE 2 END, created_at DESC
-	`)
+// envBool lê uma variável de ambiente booleana, usando o padrão quando ausente
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		fatal(key+" inválido", "value", v)
 	}
-	defer rows.Close()
+	return b
+}
 
-	var emails []EmailEntry
-	for rows.Next() {
-		var e EmailEntry
-		rows.Scan(&e.ID, &e.Alias, &e.RuleID, &e.CreatedAt, &e.ExpiresAt, &e.Status)
-		emails = append(emails, e)
+// --- WORKER DE LIMPEZA ---
+func startCleanupWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	slog.Info("Iniciando monitoramento de expiração de emails", "interval", interval.String())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkExpiredEmails(ctx, time.Now())
+			if notifyBefore > 0 {
+				notifyExpiring(time.Now())
+			}
+			if purgeAfter > 0 {
+				if n, err := purgeOld(purgeAfter); err != nil {
+					slog.Error("Erro ao limpar emails deletados e expirados", "error", err)
+				} else if n > 0 {
+					slog.Info("Purge de emails deletados e expirados", "removed", n, "older_than", formatTTL(purgeAfter))
+				}
+			}
+		}
 	}
-
-	tmpl.Execute(w, emails)
 }
 
-func handleGenerate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", 405)
-		return
+// checkExpiredEmails expira os emails vencidos até o instante now, capturado
+// uma única vez no início do ciclo. Um email criado ou renovado depois desse
+// instante fica para o próximo ciclo, então a garantia é: todo email é expirado
+// no máximo um intervalo do worker (mais o tempo das chamadas à Cloudflare)
+// depois de expires_at.
+func checkExpiredEmails(ctx context.Context, now time.Time) {
+	var renewed int
+	var expired []expiredEmail
+	for _, e := range findExpiredEmails(now) {
+		// Auto-renovação: mantém a regra e estende pelo TTL original
+		if e.autoRenew {
+			if _, err := db.Exec("UPDATE emails SET expires_at = ?, notified = 0 WHERE id = ? AND status = 'active'", now.Add(ttlOrDefault(e.ttlSeconds)), e.id); err != nil {
+				slog.Error("Erro ao renovar automaticamente", "id", e.id, "alias", e.alias, "error", err)
+				continue
+			}
+			publishEvent("renewed", e.id, e.alias, "active")
+			aliasesRenewed.Inc()
+			renewed++
+			continue
+		}
+		expired = append(expired, e)
 	}
 
-	aliasPrefix := generateRandomString(8)
-	domain := os.Getenv("CF_EMAIL_DOMAIN")
-	fullEmail := fmt.Sprintf("%s@%s", aliasPrefix, domain)
+	// Remove as regras da Cloudflare e marca de uma vez no banco só os que
+	// saíram de lá; os que falharam continuam ativos e voltam no próximo ciclo
+	removed, cfFailures := removeExpiredRules(ctx, expired)
+	if len(removed) > 0 {
+		ids := make([]interface{}, len(removed))
+		for i, e := range removed {
+			ids[i] = e.id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+		if _, err := db.Exec("UPDATE emails SET status = 'deleted', rule_id = '', deleted_at = CURRENT_TIMESTAMP WHERE status = 'active' AND id IN ("+placeholders+")", ids...); err != nil {
+			slog.Error("Erro ao marcar emails expirados", "error", err)
+			return
+		}
+		for _, e := range removed {
+			publishEvent("expired", e.id, e.alias, "deleted")
+			aliasesExpired.Inc()
+			expiryLag.Observe(time.Since(e.expiresAt).Seconds())
+		}
+	}
 
-	ruleID, err := createCFRule(fullEmail, true)
-	if err != nil {
-		http.Error(w, "Erro Cloudflare: "+err.Error(), 500)
-		return
+	if renewed > 0 || len(expired) > 0 {
+		slog.Info("Limpeza concluída", "expired", len(removed), "renewed", renewed, "cf_failures", cfFailures)
 	}
+}
 
-	// Define expiração para 1 hora a partir de agora
-	expiresAt := time.Now().Add(1 * time.Hour)
+// removeExpiredRules apaga as regras em paralelo, no máximo CLEANUP_CONCURRENCY
+// (padrão 5) chamadas simultâneas, e devolve os emails cuja regra foi removida
+func removeExpiredRules(ctx context.Context, expired []expiredEmail) ([]expiredEmail, int) {
+	sem := make(chan struct{}, cleanupConcurrency)
 
-	_, err = db.Exec("INSERT INTO emails (alias, rule_id, status, expires_at) VALUES (?, ?, 'active', ?)", fullEmail, ruleID, expiresAt)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var removed []expiredEmail
+	failures := 0
+	for _, e := range expired {
+		e := e
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var err error
+			if e.ruleID != "" {
+				err = removeEmailRule(ctx, e.ruleID, e.matcher)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.Error("Erro ao remover regra expirada", "rule_id", e.ruleID, "alias", e.alias, "error", err)
+				failures++
+				return
+			}
+			removed = append(removed, e)
+		}()
 	}
+	wg.Wait()
+	return removed, failures
+}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+type expiredEmail struct {
+	id         int
+	ruleID     string
+	alias      string
+	expiresAt  time.Time
+	autoRenew  bool
+	ttlSeconds int
+	matcher    string
 }
 
-func handleRenew(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	
-	// Adiciona 1 hora ao tempo de expiração atual
-	_, err := db.Exec("UPDATE emails SET expires_at = datetime(expires_at, '+1 hour') WHERE id = ? AND status = 'active'", id)
+// findExpiredEmails lê todos os vencidos antes de processá-los: com o cursor
+// aberto o SQLite recusaria os UPDATEs (SQLITE_BUSY)
+func findExpiredEmails(now time.Time) []expiredEmail {
+	snapshot := now.UTC().Format("2006-01-02 15:04:05.000")
+
+	// Busca emails ativos que já venceram. julianday() normaliza os dois lados
+	// para UTC, pois expires_at pode ter sido gravado pelo Go ou pelo SQLite, e
+	// ao contrário de datetime() mantém os milissegundos: com TTLs curtos um
+	// alias não pode sair até um segundo antes de expires_at. A comparação é
+	// estrita porque o SQLite arredonda expires_at ao milissegundo.
+	st, err := prepared("SELECT id, rule_id, alias, expires_at, IFNULL(auto_renew, 0), IFNULL(ttl_seconds, 0), IFNULL(matcher, 'literal') FROM emails WHERE status = 'active' AND julianday(expires_at) < julianday(?)")
 	if err != nil {
-		log.Println("Erro ao renovar:", err)
+		slog.Error("Erro ao verificar expiração", "error", err)
+		return nil
 	}
-	
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	rows, err := st.Query(snapshot)
+	if err != nil {
+		slog.Error("Erro ao verificar expiração", "error", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var expired []expiredEmail
+	for rows.Next() {
+		var e expiredEmail
+		if err := rows.Scan(&e.id, &e.ruleID, &e.alias, &e.expiresAt, &e.autoRenew, &e.ttlSeconds, &e.matcher); err != nil {
+			continue
+		}
+		expired = append(expired, e)
+	}
+	return expired
 }
 
-func handleToggle(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	var ruleID, status string
-	err := db.QueryRow("SELECT rule_id, status FROM emails WHERE id = ?", id).Scan(&ruleID, &status)
+// --- HANDLERS ---
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	// "/" no mux casa com qualquer caminho sem rota própria
+	if r.URL.Path != "/" {
+		if strings.HasPrefix(r.URL.Path, "/api/") || wantsJSON(r) {
+			writeJSONError(w, errorf(http.StatusNotFound, "rota não encontrada: %s", r.URL.Path))
+		} else {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	emails, page, err := listEmails(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, err)
 		return
 	}
 
-	newStatus := "active"
-	cfEnabled := true
-	if status == "active" {
-		newStatus = "inactive"
-		cfEnabled = false
+	localizeEntries(emails)
+
+	var stats *Stats
+	if s, err := loadStats(time.Now()); err == nil {
+		stats = &s
+	} else {
+		slog.Error("Erro ao calcular estatísticas", "error", err)
 	}
 
-	err = updateCFRule(ruleID, cfEnabled)
+	// Renderiza em memória: uma falha no meio não deve sair como página
+	// truncada com status 200
+	var buf bytes.Buffer
+	err = indexTemplate.Execute(&buf, IndexData{
+		Emails:         emails,
+		Page:           page,
+		Query:          r.URL.Query().Get("q"),
+		Status:         r.URL.Query().Get("status"),
+		Tag:            r.URL.Query().Get("tag"),
+		TTLPresets:     ttlPresets,
+		DefaultTTL:     defaultTTL,
+		Domains:        emailDomains(),
+		RefreshSeconds: uiRefreshSeconds,
+		Warnings:       configWarnings,
+		CSRFToken:      csrfToken(w, r),
+		Stats:          stats,
+
+		RequireDeleteReason: requireDeleteReason,
+	})
 	if err != nil {
-		http.Error(w, "Erro ao atualizar CF: "+err.Error(), 500)
+		slog.Error("Erro ao renderizar a página inicial", "error", err)
+		http.Error(w, "Erro ao renderizar a página", 500)
 		return
 	}
-
-	db.Exec("UPDATE emails SET status = ? WHERE id = ?", newStatus, id)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
 }
 
-func handleDelete(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	var ruleID string
-	db.QueryRow("SELECT rule_id FROM emails WHERE id = ?", id).Scan(&ruleID)
-
-	if ruleID != "" {
-		deleteCFRule(ruleID)
+// handleSearch é a versão JSON da listagem: aceita os mesmos filtros e paginação
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	emails, _, err := listEmails(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err)
+		return
 	}
-
-	db.Exec("UPDATE emails SET status = 'deleted', rule_id = '' WHERE id = ?", id)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	if emails == nil {
+		emails = []EmailEntry{}
+	}
+	writeJSON(w, http.StatusOK, emails)
 }
 
-func handleRecreate(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	var alias string
-	db.QueryRow("SELECT alias FROM emails WHERE id = ?", id).Scan(&alias)
+// ListResponse é o envelope de /api/list
+type ListResponse struct {
+	Emails     []EmailEntry `json:"emails"`
+	Total      int          `json:"total"`
+	Page       int          `json:"page"`
+	PerPage    int          `json:"per_page"`
+	TotalPages int          `json:"total_pages"`
+}
 
-	ruleID, err := createCFRule(alias, true)
+// handleList devolve a mesma listagem da interface em JSON, com os filtros
+// status, q e tag e a paginação (page, per_page)
+func handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	emails, page, err := listEmails(r.URL.Query())
 	if err != nil {
-		http.Error(w, "Erro ao recriar: "+err.Error(), 500)
+		writeJSONError(w, err)
 		return
 	}
-
-	// Ao recriar, reseta o timer para 1 hora
-	expiresAt := time.Now().Add(1 * time.Hour)
-	db.Exec("UPDATE emails SET status = 'active', rule_id = ?, expires_at = ? WHERE id = ?", ruleID, expiresAt, id)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	if emails == nil {
+		emails = []EmailEntry{}
+	}
+	writeJSON(w, http.StatusOK, ListResponse{
+		Emails:     emails,
+		Total:      page.Total,
+		Page:       page.Page,
+		PerPage:    page.PerPage,
+		TotalPages: page.TotalPages,
+	})
 }
 
-// --- CLOUDFLARE HELPERS (Mesmos de antes) ---
+// listEmails aplica filtros e paginação da query string e devolve a página pedida
+func listEmails(q url.Values) ([]EmailEntry, Pagination, error) {
+	where, args, err := buildEmailFilters(q)
+	if err != nil {
+		return nil, Pagination{}, errorf(http.StatusBadRequest, "%v", err)
+	}
+	page, err := parsePagination(q)
+	if err != nil {
+		return nil, page, errorf(http.StatusBadRequest, "%v", err)
+	}
 
-func createCFRule(email string, enabled bool) (string, error) {
-	dest := os.Getenv("CF_DESTINATION_EMAIL")
-	zoneID := os.Getenv("CF_ZONE_ID")
+	countStmt, err := prepared("SELECT COUNT(*) FROM emails" + where)
+	if err != nil {
+		return nil, page, err
+	}
+	var total int
+	if err := countStmt.QueryRow(args...).Scan(&total); err != nil {
+		return nil, page, err
+	}
+	page.setTotal(total)
 
-	reqBody := CFRequest{
-		Matchers: []CFMatcher{{Type: "literal", Field: "to", Value: email}},
-		Actions:  []CFAction{{Type: "forward", Value: []string{dest}}},
-		Enabled:  enabled,
-		Name:     "TempMail-" + email,
+	// Ordena por status (ativos primeiro) e depois por data
+	listStmt, err := prepared(`
+		SELECT ` + emailColumns + `
+		FROM emails` + where + `
+		ORDER BY CASE WHEN status='active' THEN 1 ELSE 2 END, created_at DESC
+		LIMIT ? OFFSET ?
+	`)
+	if err != nil {
+		return nil, page, err
+	}
+	rows, err := listStmt.Query(append(args, page.PerPage, page.Offset())...)
+	if err != nil {
+		return nil, page, err
+	}
+	defer rows.Close()
+
+	var emails []EmailEntry
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			slog.Error("Erro ao ler email", "error", err)
+			continue
+		}
+		emails = append(emails, e)
 	}
+	return emails, page, rows.Err()
+}
+
+// emailColumns lista as colunas lidas por scanEmail, na mesma ordem
+const emailColumns = `id, alias, IFNULL(rule_id, ''), created_at, expires_at, status,
+	IFNULL(rule_tag, ''), IFNULL(rule_priority, 0), IFNULL(rule_enabled, 0), IFNULL(tier, ''),
+	IFNULL(destination, ''), IFNULL(ttl_seconds, 0), IFNULL(auto_renew, 0),
+	IFNULL(matcher, 'literal'), IFNULL(last_error, ''), IFNULL(tags, ''), IFNULL(note, ''), deleted_at`
 
-	return callCFAPI("POST", fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/email/routing/rules", zoneID), reqBody)
+// IsCatchAll indica um alias que recebe todo o domínio (matcher "all")
+func (e EmailEntry) IsCatchAll() bool {
+	return e.Matcher == matcherAll
 }
 
-func updateCFRule(ruleID string, enabled bool) error {
-	zoneID := os.Getenv("CF_ZONE_ID")
-	payload := map[string]interface{}{"enabled": enabled}
-	_, err := callCFAPI("PATCH", fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/email/routing/rules/%s", zoneID, ruleID), payload)
-	return err
+// TTL é a duração escolhida na criação, usada por renovar e restaurar.
+// Registros antigos, sem ttl_seconds, usam DEFAULT_TTL.
+func (e EmailEntry) TTL() time.Duration {
+	return ttlOrDefault(e.TTLSeconds)
 }
 
-func deleteCFRule(ruleID string) error {
-	zoneID := os.Getenv("CF_ZONE_ID")
-	_, err := callCFAPI("DELETE", fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/email/routing/rules/%s", zoneID, ruleID), nil)
-	return err
+// TTLLabel é o TTL formatado para a UI (ex.: "15m", "1d")
+func (e EmailEntry) TTLLabel() string {
+	return formatTTL(e.TTL())
 }
 
-func callCFAPI(method, url string, body interface{}) (string, error) {
-	var bodyReader io.Reader
-	if body != nil {
-		jsonBytes, _ := json.Marshal(body)
-		bodyReader = bytes.NewBuffer(jsonBytes)
+func ttlOrDefault(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultTTL
 	}
+	return time.Duration(seconds) * time.Second
+}
 
-	req, _ := http.NewRequest(method, url, bodyReader)
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("CF_API_TOKEN"))
-	req.Header.Set("Content-Type", "application/json")
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+func scanEmail(sc rowScanner) (EmailEntry, error) {
+	var e EmailEntry
+	var expiresAt, deletedAt sql.NullTime
+	var tags string
+	err := sc.Scan(&e.ID, &e.Alias, &e.RuleID, &e.CreatedAt, &expiresAt, &e.Status, &e.RuleTag, &e.RulePriority, &e.RuleEnabled, &e.Tier, &e.Destination, &e.TTLSeconds, &e.AutoRenew, &e.Matcher, &e.LastError, &tags, &e.Note, &deletedAt)
 	if err != nil {
-		return "", err
+		return e, err
+	}
+	e.Tags = splitTags(tags)
+	if e.Destination, err = openField(e.Destination); err != nil {
+		return e, err
+	}
+	if deletedAt.Valid {
+		e.DeletedAt = &deletedAt.Time
 	}
-	defer resp.Body.Close()
-
-	respBytes, _ := io.ReadAll(resp.Body)
-	
-	var cfResp CFResponse
-	json.Unmarshal(respBytes, &cfResp)
 
-	if !cfResp.Success && method != "DELETE" {
-		if len(cfResp.Errors) > 0 {
-			return "", fmt.Errorf(cfResp.Errors[0].Message)
-		}
-		return "", fmt.Errorf("unknown error from cloudflare")
+	// Registros antigos não têm expires_at: usa created_at como fallback.
+	// Feito aqui e não no SQL porque IFNULL perde o tipo DATETIME da coluna.
+	e.ExpiresAt = e.CreatedAt
+	if expiresAt.Valid {
+		e.ExpiresAt = expiresAt.Time
 	}
+	return e, nil
+}
 
-	return cfResp.Result.ID, nil
+// Filtros de data aceitos na listagem: parâmetro -> condição SQL
+var dateFilters = []struct {
+	param string
+	cond  string
+}{
+	{"created_after", "datetime(created_at) >= datetime(?)"},
+	{"created_before", "datetime(created_at) < datetime(?)"},
+	{"expires_after", "datetime(expires_at) >= datetime(?)"},
+	{"expires_before", "datetime(expires_at) < datetime(?)"},
 }
 
-func generateRandomString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, n)
-	rand.Seed(time.Now().UnixNano())
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+var validStatuses = map[string]bool{"active": true, "inactive": true, "deleted": true, "expired": true, "failed": true}
+
+// buildEmailFilters monta o WHERE (com parâmetros) a partir da query string.
+// Datas são RFC3339 e comparadas em UTC.
+func buildEmailFilters(q url.Values) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+
+	for _, f := range dateFilters {
+		v := q.Get(f.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s deve estar no formato RFC3339: %s", f.param, v)
+		}
+		conds = append(conds, f.cond)
+		args = append(args, t.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	// Busca parcial no alias; % e _ digitados são tratados como literais
+	if v := strings.TrimSpace(q.Get("q")); v != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(v))
+		conds = append(conds, `alias LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaped+"%")
+	}
+	if v := strings.ToLower(strings.TrimSpace(q.Get("tag"))); v != "" {
+		if !tagPattern.MatchString(v) {
+			return "", nil, fmt.Errorf("tag inválida: %s", v)
+		}
+		conds = append(conds, `(',' || IFNULL(tags, '') || ',') LIKE ? ESCAPE '\'`)
+		args = append(args, "%,"+strings.ReplaceAll(v, "_", `\_`)+",%")
+	}
+	if v := q.Get("status"); v != "" {
+		if !validStatuses[v] {
+			return "", nil, fmt.Errorf("status inválido: %s", v)
+		}
+		conds = append(conds, "status = ?")
+		args = append(args, v)
+	}
+
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+func getEmail(id string) (EmailEntry, error) {
+	st, err := prepared("SELECT " + emailColumns + " FROM emails WHERE id = ?")
+	if err != nil {
+		return EmailEntry{}, err
+	}
+	return scanEmail(st.QueryRow(id))
+}
+
+// wantsJSON indica se o cliente pediu resposta JSON (API) em vez de HTML
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// wantsPlainText indica se o cliente pediu só o texto (Accept: text/plain),
+// ex.: curl -H 'Accept: text/plain' ... | pbcopy
+func wantsPlainText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+}
+
+// writeAlias responde o endereço puro, com quebra de linha para o terminal
+func writeAlias(w http.ResponseWriter, entry EmailEntry) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, entry.Alias)
+}
+
+// formID lê e valida o parâmetro id (corpo ou query): inteiro positivo,
+// erro 400 caso contrário. Devolve o id normalizado para as consultas.
+func formID(r *http.Request) (string, error) {
+	raw := r.FormValue("id")
+	id, err := strconv.Atoi(raw)
+	if err != nil || id < 1 {
+		return "", errorf(http.StatusBadRequest, "id inválido: %q", raw)
+	}
+	return strconv.Itoa(id), nil
+}
+
+// formBool lê um campo booleano de formulário ("on" é o valor de checkbox)
+func formBool(r *http.Request, key string) bool {
+	v := r.FormValue(key)
+	if v == "on" {
+		return true
+	}
+	b, _ := strconv.ParseBool(v)
+	return b
+}
+
+// apiError é um erro que já sabe qual status HTTP deve gerar
+type apiError struct {
+	Status  int
+	Message string
+	Err     error // causa original, quando houver
+}
+
+func (e *apiError) Error() string { return e.Message }
+func (e *apiError) Unwrap() error { return e.Err }
+
+func errorf(status int, format string, args ...interface{}) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// writeError responde com o status do apiError, ou 500 para outros erros
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		http.Error(w, apiErr.Message, apiErr.Status)
+		return
+	}
+	http.Error(w, err.Error(), 500)
+}
+
+// writeJSONError é o equivalente de writeError para a API JSON: {"error": "..."},
+// com os detalhes em "cloudflare" quando a causa foi um erro da Cloudflare
+func writeJSONError(w http.ResponseWriter, err error) {
+	status := 500
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.Status
+	}
+	body := map[string]interface{}{"error": err.Error()}
+	var cfErr *CFError
+	if errors.As(err, &cfErr) {
+		body["cloudflare"] = cfErr
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func handleUIDisabled(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Interface web desabilitada (UI_ENABLED=false)", http.StatusNotFound)
+}
+
+func handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	r.ParseForm()
+
+	req := GenerateRequest{
+		Prefix:       r.FormValue("prefix"),
+		Domain:       r.FormValue("domain"),
+		TTL:          r.FormValue("ttl"),
+		Tier:         r.FormValue("tier"),
+		Destination:  r.FormValue("destination"),
+		Destinations: r.Form["destinations"],
+		AutoRenew:    formBool(r, "auto_renew"),
+		Matcher:      r.FormValue("matcher"),
+		Tags:         r.Form["tags"],
+		Note:         r.FormValue("note"),
+	}
+	key, err := idempotencyKey(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.IdempotencyKey = key
+
+	newID, replayed, err := generateIdempotent(key, func() (int64, error) {
+		return generateDeduped(dedupKey(r, req), func() (int64, error) {
+			return createEmail(r.Context(), req)
+		})
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Clientes de API recebem o recurso criado em vez do redirect para a UI
+	if wantsJSON(r) {
+		entry, err := getEmail(strconv.FormatInt(newID, 10))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/email/%d", newID))
+		writeJSON(w, http.StatusCreated, entry)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleAPIGenerate é a versão JSON do generate: aceita um corpo JSON opcional
+// com os mesmos campos e sempre responde JSON, inclusive nos erros
+func handleAPIGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+
+	var req GenerateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeJSONError(w, errorf(http.StatusBadRequest, "JSON inválido: %v", err))
+			return
+		}
+	}
+
+	key, err := idempotencyKey(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	req.IdempotencyKey = key
+
+	newID, replayed, err := generateIdempotent(key, func() (int64, error) {
+		return generateDeduped(dedupKey(r, req), func() (int64, error) {
+			return createEmail(r.Context(), req)
+		})
+	})
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	entry, err := getEmail(strconv.FormatInt(newID, 10))
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/email/%d", newID))
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+// GenerateRequest são os parâmetros aceitos na criação de um email
+type GenerateRequest struct {
+	Prefix       string   `json:"prefix"` // vazio = prefixo aleatório
+	Domain       string   `json:"domain"` // vazio = primeiro de CF_EMAIL_DOMAIN
+	TTL          string   `json:"ttl"`
+	Tier         string   `json:"tier"`
+	Destination  string   `json:"destination"`
+	Destinations []string `json:"destinations"` // vários destinos: uma ação forward por endereço
+	AutoRenew    bool     `json:"auto_renew"`
+	Matcher      string   `json:"matcher"` // "all" cria o catch-all do domínio
+	Tags         []string `json:"tags"`
+	Note         string   `json:"note"`
+
+	IdempotencyKey string `json:"-"` // cabeçalho Idempotency-Key (ver idempotency.go)
+}
+
+// key identifica pedidos idênticos para a deduplicação
+func (g GenerateRequest) key() string {
+	return g.Prefix + "|" + g.Domain + "|" + g.TTL + "|" + g.Tier + "|" + g.Destination + "|" + strings.Join(g.Destinations, ",") + "|" + strconv.FormatBool(g.AutoRenew) + "|" + g.Matcher + "|" + strings.Join(g.Tags, ",") + "|" + g.Note
+}
+
+// createEmail valida o pedido, cria a regra na Cloudflare e grava a linha.
+// Erros de validação vêm como *apiError com o status HTTP adequado.
+func createEmail(ctx context.Context, req GenerateRequest) (int64, error) {
+	ttl, err := resolveTTL(req.TTL)
+	if err != nil {
+		return 0, errorf(http.StatusBadRequest, "%v", err)
+	}
+
+	// Tiers nomeados rejeitam TTL acima do limite; o padrão só ajusta ao MAX_TTL
+	limit, err := tierLimit(req.Tier)
+	if err != nil {
+		return 0, errorf(http.StatusBadRequest, "%v", err)
+	}
+	if req.Tier != "" && ttl > limit {
+		return 0, errorf(http.StatusBadRequest, "ttl excede o máximo do tier %s (%s)", req.Tier, limit)
+	}
+	ttl = clampTTL(ttl, limit)
+
+	dests, err := requestDestinations(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	destination := strings.Join(dests, ",")
+
+	tagList, err := parseTags(req.Tags)
+	if err != nil {
+		return 0, errorf(http.StatusBadRequest, "%v", err)
+	}
+	tags := strings.Join(tagList, ",")
+
+	note, ok := cleanNote(req.Note)
+	if !ok {
+		return 0, errorf(http.StatusBadRequest, "nota muito longa (máximo de %d caracteres)", maxNoteLength)
+	}
+
+	domain := strings.ToLower(req.Domain)
+	if domain == "" {
+		domain = defaultDomain()
+	} else if !isConfiguredDomain(domain) {
+		return 0, errorf(http.StatusBadRequest, "domínio não configurado: %s", domain)
+	}
+
+	matcher := matcherLiteral
+	switch req.Matcher {
+	case "", matcherLiteral:
+	case matcherAll:
+		if req.Prefix != "" {
+			return 0, errorf(http.StatusBadRequest, "prefixo não se aplica ao catch-all")
+		}
+		matcher = matcherAll
+	default:
+		return 0, errorf(http.StatusBadRequest, "matcher inválido: %s (use literal ou all)", req.Matcher)
+	}
+
+	var fullEmail string
+	if matcher == matcherAll {
+		// O catch-all é guardado como "*@dominio". Na Cloudflare ele é único
+		// por zona, e todos os domínios configurados usam a mesma CF_ZONE_ID
+		fullEmail = "*@" + domain
+		var existing string
+		err := db.QueryRow("SELECT alias FROM emails WHERE matcher = 'all' AND status IN ('active', 'inactive') LIMIT 1").Scan(&existing)
+		if err == nil {
+			return 0, errorf(http.StatusConflict, "já existe um catch-all: %s", existing)
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	} else {
+		aliasPrefix := generateAliasPrefix()
+		if req.Prefix != "" {
+			aliasPrefix = strings.ToLower(req.Prefix)
+			if err := validateAlias(aliasPrefix, domain); err != nil {
+				return 0, errorf(http.StatusBadRequest, "%v", err)
+			}
+		}
+		fullEmail = fmt.Sprintf("%s@%s", aliasPrefix, domain)
+
+		// Não cria uma segunda regra para um endereço que já está ativo
+		inUse, err := aliasInUse(fullEmail)
+		if err != nil {
+			return 0, err
+		}
+		if inUse {
+			return 0, errorf(http.StatusConflict, "%s já existe e está ativo", fullEmail)
+		}
+	}
+
+	rule, err := createEmailRule(ctx, fullEmail, destination, matcher)
+	if err != nil {
+		// Guarda a tentativa para que falhas intermitentes fiquem visíveis na UI
+		if _, dbErr := db.Exec("INSERT INTO emails (alias, rule_id, tier, destination, ttl_seconds, auto_renew, matcher, tags, note, status, expires_at, last_error) VALUES (?, '', ?, ?, ?, ?, ?, ?, ?, 'failed', ?, ?)",
+			fullEmail, req.Tier, sealField(destination), int(ttl.Seconds()), req.AutoRenew, matcher, tags, note, time.Now(), err.Error()); dbErr != nil {
+			slog.Error("Erro ao registrar geração com falha", "error", dbErr)
+		}
+		return 0, &apiError{Status: 500, Message: "Erro Cloudflare: " + err.Error(), Err: err}
+	}
+
+	expiresAt := time.Now().Add(ttl)
+
+	res, err := db.Exec("INSERT INTO emails (alias, rule_id, rule_tag, rule_priority, rule_enabled, tier, destination, ttl_seconds, auto_renew, matcher, tags, note, status, expires_at, idempotency_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'active', ?, NULLIF(?, ''))",
+		fullEmail, rule.ID, rule.Tag, rule.Priority, rule.Enabled, req.Tier, sealField(destination), int(ttl.Seconds()), req.AutoRenew, matcher, tags, note, expiresAt, req.IdempotencyKey)
+	if err != nil {
+		// Sem a linha no banco a regra ficaria órfã na Cloudflare; a remoção
+		// não é cancelada junto com a requisição
+		if delErr := removeEmailRule(context.WithoutCancel(ctx), rule.ID, matcher); delErr != nil {
+			slog.Error("Erro ao remover regra após falha no banco", "rule_id", rule.ID, "error", delErr)
+		}
+		if isUniqueViolation(err) {
+			// Outra requisição criou o mesmo alias depois da checagem de aliasInUse
+			cfRollbacks.WithLabelValues("conflict").Inc()
+			return 0, errorf(http.StatusConflict, "%s já existe e está ativo", fullEmail)
+		}
+		if isStorageError(err) {
+			cfRollbacks.WithLabelValues("storage").Inc()
+			slog.Error("Erro de armazenamento: disco cheio ou falha de I/O no banco", "error", err)
+			return 0, errorf(http.StatusInsufficientStorage, "Sem espaço para salvar o email: %v", err)
+		}
+		cfRollbacks.WithLabelValues("db_error").Inc()
+		return 0, err
+	}
+
+	newID, _ := res.LastInsertId()
+	publishEvent("created", int(newID), fullEmail, "active")
+	aliasesCreated.Inc()
+	return newID, nil
+}
+
+func handleRenew(w http.ResponseWriter, r *http.Request) {
+	if !mutationAllowed(w, r) {
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Token de renovação é opcional, mas se vier precisa ser válido para este id
+	if token := r.FormValue("token"); token != "" && !validRenewToken(token, id) {
+		http.Error(w, "Token de renovação inválido", http.StatusForbidden)
+		return
+	}
+
+	renewed, err := renewEmail(id)
+	if err != nil {
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) {
+			slog.Error("Erro ao renovar", "id", id, "error", err)
+		}
+		writeError(w, err)
+		return
+	}
+	if !renewed {
+		writeError(w, errorf(http.StatusNotFound, "email não encontrado ou não está ativo"))
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renewal calcula o novo vencimento de um email ativo: o vencimento atual mais o
+// TTL original, limitado ao TTL máximo do tier. Um tier que saiu de
+// TIER_MAX_TTL cai no MAX_TTL, como o tier padrão.
+func renewal(e EmailEntry, now time.Time) (time.Time, error) {
+	limit, err := tierLimit(e.Tier)
+	if err != nil {
+		limit = maxTTL
+	}
+	next := e.ExpiresAt.Add(e.TTL())
+	if limit > 0 && next.Sub(now) > limit {
+		return time.Time{}, errorf(http.StatusBadRequest, "renovação excede o TTL máximo (%s)", limit)
+	}
+	return next, nil
+}
+
+// renewEmail estende a expiração pelo TTL original, respeitando o TTL máximo
+// do tier. Retorna false quando o email não existe ou não está ativo.
+func renewEmail(id string) (bool, error) {
+	entry, err := getEmail(id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if entry.Status != "active" {
+		return false, nil
+	}
+	next, err := renewal(entry, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	res, err := db.Exec("UPDATE emails SET expires_at = ?, notified = 0 WHERE id = ? AND status = 'active'", next, id)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	publishEvent("renewed", entry.ID, entry.Alias, "active")
+	aliasesRenewed.Inc()
+	return true, nil
+}
+
+// handleRenewAll estende todos os emails ativos pelo TTL de cada um, com as
+// mesmas regras da renovação individual (renewal): os que passariam do TTL
+// máximo do tier ficam de fora. Tudo roda em uma transação e cada alias
+// renovado gera seu evento "renewed".
+func handleRenewAll(w http.ResponseWriter, r *http.Request) {
+	if !mutationAllowed(w, r) {
+		return
+	}
+
+	renewed, skipped, err := renewAllActive(time.Now())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for _, e := range renewed {
+		publishEvent("renewed", e.ID, e.Alias, "active")
+	}
+	aliasesRenewed.Add(float64(len(renewed)))
+	slog.Info("Emails ativos renovados em lote", "renewed", len(renewed), "skipped", skipped)
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]int{"renewed": len(renewed), "skipped": skipped})
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renewAllActive renova, em uma transação, os emails ativos que cabem no
+// limite do tier. Devolve os renovados e quantos ficaram de fora.
+func renewAllActive(now time.Time) ([]EmailEntry, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT " + emailColumns + " FROM emails WHERE status = 'active'")
+	if err != nil {
+		return nil, 0, err
+	}
+	var active []EmailEntry
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		active = append(active, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var renewed []EmailEntry
+	skipped := 0
+	for _, e := range active {
+		next, err := renewal(e, now)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if _, err := tx.Exec("UPDATE emails SET expires_at = ?, notified = 0 WHERE id = ?", next, e.ID); err != nil {
+			return nil, 0, err
+		}
+		renewed = append(renewed, e)
+	}
+	return renewed, skipped, tx.Commit()
+}
+
+// handleAutoRenew liga/desliga a renovação automática de um email
+func handleAutoRenew(w http.ResponseWriter, r *http.Request) {
+	if !mutationAllowed(w, r) {
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := db.Exec("UPDATE emails SET auto_renew = NOT IFNULL(auto_renew, 0) WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "email não encontrado", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleValidate verifica se um prefixo é válido e está disponível antes da criação
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.ToLower(r.URL.Query().Get("prefix"))
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		domain = defaultDomain()
+	}
+
+	result := struct {
+		Valid     bool   `json:"valid"`
+		Available bool   `json:"available"`
+		Reason    string `json:"reason,omitempty"`
+	}{}
+
+	if err := validateAlias(prefix, domain); err != nil {
+		result.Reason = err.Error()
+	} else {
+		result.Valid = true
+		inUse, err := aliasInUse(fmt.Sprintf("%s@%s", prefix, domain))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		result.Available = !inUse
+		if inUse {
+			result.Reason = "alias já está em uso"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// validateAlias aplica as regras de formato do prefixo e do domínio
+func validateAlias(prefix, domain string) error {
+	if !prefixPattern.MatchString(prefix) {
+		return fmt.Errorf("prefixo deve conter de 1 a 32 caracteres [a-z0-9._-]")
+	}
+	if !isConfiguredDomain(domain) {
+		return fmt.Errorf("domínio não configurado: %s", domain)
+	}
+	return nil
+}
+
+// requestDestinations junta destination e destinations do pedido (ambos aceitam
+// listas separadas por vírgula) e valida cada endereço. Com DESTINATION_VERIFY=true
+// exige que todos já estejam verificados na conta (precisa de CF_ACCOUNT_ID).
+func requestDestinations(ctx context.Context, req GenerateRequest) ([]string, error) {
+	var dests []string
+	seen := map[string]bool{}
+	for _, raw := range append([]string{req.Destination}, req.Destinations...) {
+		for _, dest := range splitDestinations(raw) {
+			if addr, err := mail.ParseAddress(dest); err != nil || addr.Address != dest {
+				return nil, errorf(http.StatusBadRequest, "destino inválido: %s", dest)
+			}
+			if err := checkDestination(dest); err != nil {
+				return nil, errorf(http.StatusForbidden, "%v", err)
+			}
+			if key := strings.ToLower(dest); !seen[key] {
+				seen[key] = true
+				dests = append(dests, dest)
+			}
+		}
+	}
+
+	if len(dests) > 0 && destinationVerify {
+		verified, err := verifiedCFDestinations(ctx)
+		if err != nil {
+			return nil, &apiError{Status: 502, Message: "Erro ao consultar destinos na Cloudflare: " + err.Error(), Err: err}
+		}
+		for _, dest := range dests {
+			if !verified[strings.ToLower(dest)] {
+				return nil, errorf(http.StatusBadRequest, "destino não verificado na Cloudflare: %s", dest)
+			}
+		}
+	}
+	return dests, nil
+}
+
+// splitDestinations quebra uma lista de destinos separada por vírgulas
+func splitDestinations(s string) []string {
+	var dests []string
+	for _, d := range strings.Split(s, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			dests = append(dests, d)
+		}
+	}
+	return dests
+}
+
+// forwardActions monta uma ação forward por destino; dest vazio usa CF_DESTINATION_EMAIL
+func forwardActions(dest string) []CFAction {
+	if dest == "" {
+		dest = os.Getenv("CF_DESTINATION_EMAIL")
+	}
+	var actions []CFAction
+	for _, d := range splitDestinations(dest) {
+		actions = append(actions, CFAction{Type: "forward", Value: []string{d}})
+	}
+	return actions
+}
+
+// checkDestination confere um destino informado na requisição contra
+// DESTINATION_ALLOWLIST; sem a lista, qualquer domínio é aceito
+func checkDestination(dest string) error {
+	allowlist := os.Getenv("DESTINATION_ALLOWLIST")
+	if allowlist == "" {
+		return nil
+	}
+	domain := strings.ToLower(dest[strings.LastIndex(dest, "@")+1:])
+	for _, allowed := range strings.Split(allowlist, ",") {
+		if strings.ToLower(strings.TrimSpace(allowed)) == domain {
+			return nil
+		}
+	}
+	return fmt.Errorf("domínio de destino não permitido: %s", domain)
+}
+
+// emailDomains lê CF_EMAIL_DOMAIN, que pode ser uma lista separada por vírgulas.
+// Todos os domínios precisam estar na zona CF_ZONE_ID.
+func emailDomains() []string {
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("CF_EMAIL_DOMAIN"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// defaultDomain é o primeiro domínio configurado
+func defaultDomain() string {
+	if domains := emailDomains(); len(domains) > 0 {
+		return domains[0]
+	}
+	return ""
+}
+
+func isConfiguredDomain(domain string) bool {
+	for _, d := range emailDomains() {
+		if d == strings.ToLower(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasInUse indica se já existe uma entrada ativa com o mesmo email
+func aliasInUse(email string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM emails WHERE alias = ? AND status = 'active'", email).Scan(&count)
+	return count > 0, err
+}
+
+// handleEmailsStatus retorna o status de vários emails em uma única chamada:
+// id -> {status, expires_in_seconds, message_count}. Aceita ?ids=1,2,3 ou um
+// array JSON no corpo.
+func handleEmailsStatus(w http.ResponseWriter, r *http.Request) {
+	var ids []int
+	if raw := r.URL.Query().Get("ids"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				http.Error(w, "id inválido: "+part, http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+	} else if r.Body != nil && r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+			http.Error(w, "corpo deve ser um array JSON de ids", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(ids) == 0 {
+		http.Error(w, "nenhum id informado", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxStatusIDs {
+		http.Error(w, fmt.Sprintf("máximo de %d ids por requisição", maxStatusIDs), http.StatusBadRequest)
+		return
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := db.Query("SELECT "+emailColumns+" FROM emails WHERE id IN ("+placeholders+")", args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	var entries []EmailEntry
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	// message_count vem da caixa de entrada (inbox.go): mensagens recebidas
+	// desde a criação da linha. Fica null quando a contagem falha.
+	type emailStatus struct {
+		Status           string `json:"status"`
+		ExpiresInSeconds int64  `json:"expires_in_seconds"`
+		MessageCount     *int   `json:"message_count"`
+	}
+	result := map[string]emailStatus{}
+	for _, e := range entries {
+		st := emailStatus{Status: e.Status}
+		if e.Status == "active" {
+			if remaining := time.Until(e.ExpiresAt); remaining > 0 {
+				st.ExpiresInSeconds = int64(remaining.Seconds())
+			}
+		}
+		if n, err := inbox.Count(r.Context(), strings.ToLower(e.Alias), e.CreatedAt); err == nil {
+			st.MessageCount = &n
+		} else {
+			slog.Error("Erro ao contar mensagens", "id", e.ID, "alias", e.Alias, "error", err)
+		}
+		result[strconv.Itoa(e.ID)] = st
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleEmailRoutes atende as rotas /api/email/{id}/...
+func handleEmailRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/email/"), "/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1 && id != "":
+		handleGetEmail(w, r, id)
+	case len(parts) == 2 && parts[1] == "renew-token":
+		handleRenewToken(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleEmailByQuery atende /api/email?id=: mesmo recurso de /api/email/{id},
+// com o id validado e erros em JSON. Emails deletados continuam visíveis
+// (status deleted e deleted_at) até o purge; depois disso dão 404.
+func handleEmailByQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	entry, err := getEmail(id)
+	if err == sql.ErrNoRows {
+		writeJSONError(w, errorf(http.StatusNotFound, "email não encontrado"))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if wantsPlainText(r) {
+		writeAlias(w, entry)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// handleAlias devolve só o endereço em text/plain (/api/alias?id=)
+func handleAlias(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	entry, err := getEmail(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "email não encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeAlias(w, entry)
+}
+
+func handleGetEmail(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	entry, err := getEmail(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if wantsPlainText(r) {
+		writeAlias(w, entry)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+func handleRenewToken(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	if len(renewTokenSecret()) == 0 {
+		http.Error(w, "RENEW_TOKEN_SECRET não configurado", http.StatusServiceUnavailable)
+		return
+	}
+
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM emails WHERE id = ?", id).Scan(&exists); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"token": signRenewToken(id)})
+}
+
+// mutationAllowed aceita só POST nas rotas que alteram um email; GET continua
+// funcionando com ALLOW_GET_MUTATIONS=true para links antigos, mas fica sem a
+// proteção CSRF e sujeito a prefetch
+func mutationAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost || (r.Method == http.MethodGet && allowGetMutations) {
+		return true
+	}
+	http.Error(w, "Method not allowed", 405)
+	return false
+}
+
+func handleToggle(w http.ResponseWriter, r *http.Request) {
+	if !mutationAllowed(w, r) {
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, err := toggleEmail(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// toggleEmail pausa um email ativo ou reativa um pausado e retorna o novo status.
+// A Cloudflare é chamada fora de qualquer transação (com retries e esperas do
+// Retry-After, um lock de escrita aberto travaria os outros escritores) e o
+// banco só é gravado depois que ela confirmar. Se a gravação falhar, a regra
+// volta ao estado anterior na Cloudflare e os dois continuam consistentes.
+func toggleEmail(ctx context.Context, id string) (string, error) {
+	var ruleID, alias, status, destination, matcher string
+	err := db.QueryRow("SELECT IFNULL(rule_id, ''), alias, status, IFNULL(destination, ''), IFNULL(matcher, 'literal') FROM emails WHERE id = ?", id).Scan(&ruleID, &alias, &status, &destination, &matcher)
+	if err == sql.ErrNoRows {
+		return "", errorf(http.StatusNotFound, "email não encontrado")
+	}
+	if err == nil {
+		destination, err = openField(destination)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Expirados/deletados não têm regra: precisam ser restaurados, não reativados
+	if status != "active" && status != "inactive" {
+		return "", errorf(http.StatusConflict, "email %s não pode ser pausado/reativado", status)
+	}
+
+	newStatus := "active"
+	cfEnabled := true
+	if status == "active" {
+		newStatus = "inactive"
+		cfEnabled = false
+	}
+
+	// Reativar não pode duplicar um alias que já voltou a existir em outra
+	// linha; checado antes para não ligar a regra à toa
+	if cfEnabled {
+		inUse, err := aliasInUse(alias)
+		if err != nil {
+			return "", err
+		}
+		if inUse {
+			return "", errorf(http.StatusConflict, "o alias já está ativo em outra linha")
+		}
+	}
+
+	if err := setEmailRuleEnabled(ctx, ruleID, destination, matcher, cfEnabled); err != nil {
+		return "", &apiError{Status: 502, Message: "Erro ao atualizar CF (status mantido): " + err.Error(), Err: err}
+	}
+
+	// O status antigo no WHERE detecta uma alteração concorrente da linha
+	res, err := db.Exec("UPDATE emails SET status = ?, rule_enabled = ? WHERE id = ? AND status = ?", newStatus, cfEnabled, id, status)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			err = errorf(http.StatusConflict, "o email foi alterado durante a operação")
+		}
+	}
+	if err != nil {
+		// A Cloudflare já mudou: desfaz lá para não divergir do banco
+		if revertErr := setEmailRuleEnabled(context.WithoutCancel(ctx), ruleID, destination, matcher, !cfEnabled); revertErr != nil {
+			slog.Error("Erro ao reverter regra na Cloudflare", "rule_id", ruleID, "error", revertErr)
+		}
+		var apiErr *apiError
+		switch {
+		case errors.As(err, &apiErr):
+			return "", err
+		case isUniqueViolation(err):
+			return "", errorf(http.StatusConflict, "o alias já está ativo em outra linha")
+		}
+		return "", fmt.Errorf("Erro ao salvar status: %w", err)
+	}
+
+	idNum, _ := strconv.Atoi(id)
+	publishEvent("toggled", idNum, alias, newStatus)
+	return newStatus, nil
+}
+
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	if !mutationAllowed(w, r) {
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Motivo vai para o audit_log; obrigatório com REQUIRE_DELETE_REASON=true
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if err := deleteEmail(r.Context(), id, reason); err != nil {
+		writeError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// deleteEmail remove a regra na Cloudflare, marca a linha como deletada e
+// registra o motivo no audit_log
+func deleteEmail(ctx context.Context, id, reason string) error {
+	if reason == "" && requireDeleteReason {
+		return errorf(http.StatusBadRequest, "Informe o motivo da exclusão (reason)")
+	}
+	var ruleID, alias, matcher string
+	err := db.QueryRow("SELECT rule_id, alias, IFNULL(matcher, 'literal') FROM emails WHERE id = ?", id).Scan(&ruleID, &alias, &matcher)
+	if err == sql.ErrNoRows {
+		return errorf(http.StatusNotFound, "email não encontrado")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Se a Cloudflare falhar a linha fica como está: apagar o rule_id deixaria
+	// uma regra encaminhando emails sem ninguém saber que ela existe
+	if ruleID != "" {
+		if err := removeEmailRule(ctx, ruleID, matcher); err != nil {
+			return &apiError{Status: 502, Message: "Erro ao remover regra na Cloudflare (email mantido): " + err.Error(), Err: err}
+		}
+	}
+
+	if _, err := db.Exec("UPDATE emails SET status = 'deleted', rule_id = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	idNum, _ := strconv.Atoi(id)
+	recordAudit(idNum, "delete", reason)
+	publishEvent("deleted", idNum, alias, "deleted")
+	aliasesDeleted.Inc()
+	return nil
+}
+
+func handleRecreate(w http.ResponseWriter, r *http.Request) {
+	if !mutationAllowed(w, r) {
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var alias, destination, matcher string
+	var ttlSeconds int
+	err = db.QueryRow("SELECT alias, IFNULL(destination, ''), IFNULL(ttl_seconds, 0), IFNULL(matcher, 'literal') FROM emails WHERE id = ?", id).Scan(&alias, &destination, &ttlSeconds, &matcher)
+	if err == sql.ErrNoRows {
+		writeError(w, errorf(http.StatusNotFound, "email não encontrado"))
+		return
+	}
+	if err == nil {
+		destination, err = openField(destination)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// Não ressuscita um alias que já voltou a existir em outra linha ativa
+	var active int
+	if err := db.QueryRow("SELECT COUNT(*) FROM emails WHERE alias = ? AND status = 'active' AND id != ?", alias, id).Scan(&active); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if active > 0 {
+		writeError(w, errorf(http.StatusConflict, "%s já está ativo em outra linha", alias))
+		return
+	}
+
+	rule, err := createEmailRule(r.Context(), alias, destination, matcher)
+	if err != nil {
+		http.Error(w, "Erro ao recriar: "+err.Error(), 500)
+		return
+	}
+
+	// Ao recriar, reseta o timer para o TTL original
+	expiresAt := time.Now().Add(ttlOrDefault(ttlSeconds))
+	res, err := db.Exec("UPDATE emails SET status = 'active', rule_id = ?, rule_tag = ?, rule_priority = ?, rule_enabled = ?, expires_at = ?, last_error = NULL, notified = 0 WHERE id = ?",
+		rule.ID, rule.Tag, rule.Priority, rule.Enabled, expiresAt, id)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			err = errorf(http.StatusNotFound, "email não encontrado")
+		}
+	} else if isUniqueViolation(err) {
+		err = errorf(http.StatusConflict, "%s já está ativo em outra linha", alias)
+	}
+	if err != nil {
+		// A linha sumiu ou o banco falhou: não deixa a regra nova órfã
+		if delErr := removeEmailRule(context.WithoutCancel(r.Context()), rule.ID, matcher); delErr != nil {
+			slog.Error("Erro ao remover regra após falha no banco", "rule_id", rule.ID, "error", delErr)
+		}
+		writeError(w, err)
+		return
+	}
+
+	idNum, _ := strconv.Atoi(id)
+	publishEvent("recreated", idNum, alias, "active")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleHealthz é a sonda de liveness/readiness: verifica o banco e, com
+// HEALTHZ_CHECK_CF=true, o token/zona na Cloudflare (conta no limite da API).
+// Com CF_DRY_RUN não há Cloudflare a consultar e a checagem fica "dry-run".
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"db": "ok", "cloudflare": "skipped"}
+	healthy := true
+
+	if err := db.Ping(); err != nil {
+		checks["db"] = err.Error()
+		healthy = false
+	}
+
+	switch {
+	case !healthzCheckCF:
+	case cfDryRun:
+		checks["cloudflare"] = "dry-run"
+	default:
+		// Uma única tentativa: a sonda não deve esperar pelos retries
+		zoneURL := fmt.Sprintf("%s/zones/%s/email/routing", cfBaseURL, os.Getenv("CF_ZONE_ID"))
+		if _, err := doCFRequest(r.Context(), "GET", zoneURL, nil, nil); err != nil {
+			checks["cloudflare"] = err.Error()
+			healthy = false
+		} else {
+			checks["cloudflare"] = "ok"
+		}
+	}
+
+	status, code := "ok", http.StatusOK
+	if !healthy {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+// handleCatchAll consulta (GET) ou define (POST action=drop|forward) o que a
+// Cloudflare faz com emails para endereços sem regra no domínio
+func handleCatchAll(w http.ResponseWriter, r *http.Request) {
+	var rule CFRule
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		rule, err = getCFCatchAll(r.Context())
+	case http.MethodPost:
+		rule, err = setCFCatchAll(r.Context(), r.FormValue("action"), r.FormValue("destination"))
+	default:
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Erro Cloudflare: "+err.Error(), 500)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// handleVerifyDestination (re)envia o email de verificação da Cloudflare para
+// um endereço de destino. Sem verificação a Cloudflare não encaminha nada.
+func handleVerifyDestination(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	email := r.FormValue("email")
+	if email == "" {
+		// Com vários destinos padrão, verifica o primeiro
+		if dests := splitDestinations(os.Getenv("CF_DESTINATION_EMAIL")); len(dests) > 0 {
+			email = dests[0]
+		}
+	}
+
+	addr, err := resendCFVerification(r.Context(), email)
+	if err != nil {
+		http.Error(w, "Erro Cloudflare: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, addr)
+}
+
+// handleActiveTxt lista os aliases ativos, um por linha, para uso em scripts
+func handleActiveTxt(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT alias FROM emails WHERE status = 'active'"
+	var args []interface{}
+	if domain := r.URL.Query().Get("domain"); domain != "" {
+		query += " AND alias LIKE ?"
+		args = append(args, "%@"+domain)
+	}
+
+	rows, err := db.Query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			slog.Error("Erro ao ler alias", "error", err)
+			return
+		}
+		fmt.Fprintln(w, alias)
+	}
+}
+
+// handleForceExpire (/api/expire) expira um email imediatamente, independente
+// do TTL: apaga a regra e marca como 'expired' com expires_at agora, mantendo
+// a linha. Diferente do delete (status 'deleted', exclusão pelo usuário) e do
+// toggle (regra continua na Cloudflare, desativada). O motivo vai para o
+// audit_log e a resposta é a entrada atualizada.
+func handleForceExpire(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	entry, err := getEmail(id)
+	if err == sql.ErrNoRows {
+		writeJSONError(w, errorf(http.StatusNotFound, "email não encontrado"))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if entry.Status != "active" && entry.Status != "inactive" {
+		writeJSONError(w, errorf(http.StatusConflict, "email já está %s", entry.Status))
+		return
+	}
+
+	if entry.RuleID != "" {
+		if err := removeEmailRule(r.Context(), entry.RuleID, entry.Matcher); err != nil {
+			writeJSONError(w, &apiError{Status: 502, Message: "Erro Cloudflare: " + err.Error(), Err: err})
+			return
+		}
+	}
+	// O status é conferido de novo no UPDATE: outra requisição pode ter
+	// apagado ou expirado o email depois da leitura
+	res, err := db.Exec("UPDATE emails SET status = 'expired', rule_id = '', expires_at = ? WHERE id = ? AND status IN ('active', 'inactive')", time.Now(), id)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSONError(w, errorf(http.StatusConflict, "email mudou de status durante a operação"))
+		return
+	}
+	aliasesExpired.Inc()
+
+	slog.Info("Email expirado manualmente", "id", entry.ID, "alias", entry.Alias)
+	recordAudit(entry.ID, "force-expire", r.FormValue("reason"))
+	publishEvent("expired", entry.ID, entry.Alias, "expired")
+
+	entry, err = getEmail(id)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// --- CLOUDFLARE HELPERS (Mesmos de antes) ---
+
+// Cliente HTTP e URL base da API da Cloudflare. São variáveis para que testes
+// possam apontá-los para um httptest.Server; CF_API_BASE_URL também troca a
+// URL em execução (ex.: um mock local).
+var (
+	cfBaseURL    = "https://api.cloudflare.com/client/v4"
+	cfHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// initCFClient aplica CF_HTTP_TIMEOUT (padrão 10s) e um Transport que mantém
+// conexões ociosas com a API, para as chamadas em sequência das operações em
+// lote reaproveitarem a conexão TLS em vez de abrir uma nova a cada regra
+func initCFClient() {
+	timeout := 10 * time.Second
+	if v := os.Getenv("CF_HTTP_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			fatal("CF_HTTP_TIMEOUT inválido", "value", v)
+		}
+		timeout = d
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 32
+	transport.MaxIdleConnsPerHost = 16 // o padrão (2) é pouco com várias requisições simultâneas
+	transport.IdleConnTimeout = 90 * time.Second
+	cfHTTPClient = &http.Client{Timeout: timeout, Transport: transport}
+
+	if v := os.Getenv("CF_API_BASE_URL"); v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("CF_API_BASE_URL inválida", "value", v)
+		}
+		cfBaseURL = strings.TrimRight(v, "/")
+	}
+}
+
+// createCFRule cria a regra de encaminhamento para um ou mais destinos
+// (separados por vírgula); dest vazio usa CF_DESTINATION_EMAIL
+func createCFRule(ctx context.Context, email, dest string, enabled bool) (CFRule, error) {
+	zoneID := os.Getenv("CF_ZONE_ID")
+
+	reqBody := CFRequest{
+		Matchers: []CFMatcher{{Type: "literal", Field: "to", Value: email}},
+		Actions:  forwardActions(dest),
+		Enabled:  enabled,
+		Name:     "TempMail-" + email,
+	}
+	if cfDryRun {
+		return dryRunRule(dryRunRuleID(email), reqBody), nil
+	}
+
+	return callCFAPI(ctx, "POST", fmt.Sprintf("%s/zones/%s/email/routing/rules", cfBaseURL, zoneID), reqBody)
+}
+
+// Tipos de alias: literal é um endereço único; all é o catch-all do domínio,
+// que na Cloudflare é a regra catch_all da zona e não uma regra comum
+const (
+	matcherLiteral = "literal"
+	matcherAll     = "all"
+)
+
+// createEmailRule cria a regra adequada ao tipo do alias
+func createEmailRule(ctx context.Context, email, dest, matcher string) (CFRule, error) {
+	if matcher != matcherAll {
+		return createCFRule(ctx, email, dest, true)
+	}
+	rule, err := setCFCatchAll(ctx, "forward", dest)
+	if err == nil && rule.ID == "" {
+		rule.ID = "catch_all"
+	}
+	return rule, err
+}
+
+// Ação do catch-all da zona quando nenhum alias catch-all está ativo
+// (CATCH_ALL_ACTION/CATCH_ALL_DESTINATION, padrão drop)
+var (
+	catchAllAction      = "drop"
+	catchAllDestination string
+)
+
+func initCatchAll() {
+	if v := os.Getenv("CATCH_ALL_ACTION"); v != "" {
+		if v != "drop" && v != "forward" {
+			fatal("CATCH_ALL_ACTION inválido (use drop ou forward)", "value", v)
+		}
+		catchAllAction = v
+	}
+	catchAllDestination = os.Getenv("CATCH_ALL_DESTINATION")
+}
+
+// restoreCatchAll devolve o catch-all da zona à ação configurada
+func restoreCatchAll(ctx context.Context) (CFRule, error) {
+	return setCFCatchAll(ctx, catchAllAction, catchAllDestination)
+}
+
+// removeEmailRule apaga a regra; o catch-all não pode ser apagado e volta à
+// ação configurada para a zona
+func removeEmailRule(ctx context.Context, ruleID, matcher string) error {
+	if matcher == matcherAll {
+		_, err := restoreCatchAll(ctx)
+		return err
+	}
+	return deleteCFRule(ctx, ruleID)
+}
+
+// setEmailRuleEnabled pausa/reativa a regra do alias. Pausar o alias catch-all
+// devolve o catch-all à ação configurada em vez de desligá-lo, o que passaria a
+// rejeitar os emails para endereços sem regra.
+func setEmailRuleEnabled(ctx context.Context, ruleID, dest, matcher string, enabled bool) error {
+	if matcher == matcherAll {
+		var err error
+		if enabled {
+			_, err = setCFCatchAll(ctx, "forward", dest)
+		} else {
+			_, err = restoreCatchAll(ctx)
+		}
+		return err
+	}
+	return updateCFRule(ctx, ruleID, enabled)
+}
+
+func updateCFRule(ctx context.Context, ruleID string, enabled bool) error {
+	if cfDryRun {
+		slog.Info("CF_DRY_RUN: atualização não enviada", "rule_id", ruleID, "enabled", enabled)
+		return nil
+	}
+	zoneID := os.Getenv("CF_ZONE_ID")
+	payload := map[string]interface{}{"enabled": enabled}
+	_, err := callCFAPI(ctx, "PATCH", fmt.Sprintf("%s/zones/%s/email/routing/rules/%s", cfBaseURL, zoneID, ruleID), payload)
+	return err
+}
+
+func deleteCFRule(ctx context.Context, ruleID string) error {
+	if cfDryRun {
+		slog.Info("CF_DRY_RUN: remoção não enviada", "rule_id", ruleID)
+		return nil
+	}
+	zoneID := os.Getenv("CF_ZONE_ID")
+	_, err := callCFAPI(ctx, "DELETE", fmt.Sprintf("%s/zones/%s/email/routing/rules/%s", cfBaseURL, zoneID, ruleID), nil)
+	return err
+}
+
+// verifyCFToken confere se o token é válido e está ativo
+func verifyCFToken(ctx context.Context) error {
+	var result struct {
+		Status string `json:"status"`
+	}
+	if err := callCFAPIInto(ctx, "GET", cfBaseURL+"/user/tokens/verify", nil, &result); err != nil {
+		return err
+	}
+	if result.Status != "active" {
+		return fmt.Errorf("token com status %q", result.Status)
+	}
+	return nil
+}
+
+// checkCFZone consulta as configurações de roteamento de email da zona
+func checkCFZone(ctx context.Context) error {
+	zoneID := os.Getenv("CF_ZONE_ID")
+	return callCFAPIInto(ctx, "GET", fmt.Sprintf("%s/zones/%s/email/routing", cfBaseURL, zoneID), nil, nil)
+}
+
+// listCFRules busca todas as regras de roteamento da zona, página por página
+func listCFRules(ctx context.Context) ([]CFRule, error) {
+	zoneID := os.Getenv("CF_ZONE_ID")
+	var all []CFRule
+	for page := 1; ; page++ {
+		var rules []CFRule
+		info, err := callCFAPIPage(ctx, "GET", fmt.Sprintf("%s/zones/%s/email/routing/rules?page=%d&per_page=50", cfBaseURL, zoneID, page), nil, &rules)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, rules...)
+		if len(rules) == 0 || page >= info.TotalPages {
+			return all, nil
+		}
+	}
+}
+
+func getCFCatchAll(ctx context.Context) (CFRule, error) {
+	zoneID := os.Getenv("CF_ZONE_ID")
+	return callCFAPI(ctx, "GET", fmt.Sprintf("%s/zones/%s/email/routing/rules/catch_all", cfBaseURL, zoneID), nil)
+}
+
+// setCFCatchAll define a ação catch-all: "drop" descarta e "forward" encaminha
+// para dest (ou CF_DESTINATION_EMAIL quando vazio)
+func setCFCatchAll(ctx context.Context, action, dest string) (CFRule, error) {
+	return putCFCatchAll(ctx, action, dest, true)
+}
+
+func putCFCatchAll(ctx context.Context, action, dest string, enabled bool) (CFRule, error) {
+	zoneID := os.Getenv("CF_ZONE_ID")
+
+	var actions []CFAction
+	switch action {
+	case "drop":
+		actions = []CFAction{{Type: "drop"}}
+	case "forward":
+		actions = forwardActions(dest)
+	default:
+		return CFRule{}, fmt.Errorf("ação de catch-all inválida: %q (use drop ou forward)", action)
+	}
+
+	reqBody := CFRequest{
+		Matchers: []CFMatcher{{Type: "all"}},
+		Actions:  actions,
+		Enabled:  enabled,
+		Name:     "TempMail-catch-all",
+	}
+	if cfDryRun {
+		return dryRunRule("catch_all", reqBody), nil
+	}
+	return callCFAPI(ctx, "PUT", fmt.Sprintf("%s/zones/%s/email/routing/rules/catch_all", cfBaseURL, zoneID), reqBody)
+}
+
+// CFDestination é um endereço de destino cadastrado na conta
+type CFDestination struct {
+	ID       string `json:"id"`
+	Email    string `json:"email"`
+	Verified string `json:"verified"` // data da verificação, vazio se pendente
+}
+
+func cfAddressesURL() string {
+	return fmt.Sprintf("%s/accounts/%s/email/routing/addresses", cfBaseURL, os.Getenv("CF_ACCOUNT_ID"))
+}
+
+// verifiedCFDestinations lista os destinos verificados da conta (em minúsculas)
+func verifiedCFDestinations(ctx context.Context) (map[string]bool, error) {
+	if os.Getenv("CF_ACCOUNT_ID") == "" {
+		return nil, fmt.Errorf("CF_ACCOUNT_ID não configurado")
+	}
+	var addrs []CFDestination
+	if err := callCFAPIInto(ctx, "GET", cfAddressesURL()+"?per_page=50", nil, &addrs); err != nil {
+		return nil, err
+	}
+	verified := map[string]bool{}
+	for _, d := range addrs {
+		if d.Verified != "" {
+			verified[strings.ToLower(d.Email)] = true
+		}
+	}
+	return verified, nil
+}
+
+// resendCFVerification dispara novamente o email de verificação. A Cloudflare
+// só envia o link na criação, então um destino pendente é removido e recriado;
+// destinos já verificados são retornados sem alteração.
+func resendCFVerification(ctx context.Context, email string) (CFDestination, error) {
+	if os.Getenv("CF_ACCOUNT_ID") == "" {
+		return CFDestination{}, fmt.Errorf("CF_ACCOUNT_ID não configurado")
+	}
+
+	var existing []CFDestination
+	if err := callCFAPIInto(ctx, "GET", cfAddressesURL()+"?per_page=50", nil, &existing); err != nil {
+		return CFDestination{}, err
+	}
+	for _, d := range existing {
+		if !strings.EqualFold(d.Email, email) {
+			continue
+		}
+		if d.Verified != "" {
+			return d, nil
+		}
+		if err := callCFAPIInto(ctx, "DELETE", cfAddressesURL()+"/"+d.ID, nil, nil); err != nil {
+			return CFDestination{}, err
+		}
+	}
+
+	var created CFDestination
+	err := callCFAPIInto(ctx, "POST", cfAddressesURL(), map[string]string{"email": email}, &created)
+	return created, err
+}
+
+// setCFHeaders aplica autenticação, User-Agent e os cabeçalhos extras de
+// CF_EXTRA_HEADERS ("Nome=valor,Outro=valor") a uma requisição para a Cloudflare
+func setCFHeaders(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("CF_API_TOKEN"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	if extra := os.Getenv("CF_EXTRA_HEADERS"); extra != "" {
+		for _, pair := range strings.Split(extra, ",") {
+			if name, value, ok := strings.Cut(pair, "="); ok {
+				req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+		}
+	}
+}
+
+// CFError é uma resposta de erro da Cloudflare: o status HTTP e todos os
+// erros retornados, com seus códigos, para que a API possa inspecioná-los
+type CFError struct {
+	Status     int             `json:"status"`
+	Errors     []CFErrorDetail `json:"errors,omitempty"`
+	Message    string          `json:"message,omitempty"` // resumo quando não há Errors, ou uma dica
+	RetryAfter time.Duration   `json:"-"`                 // do cabeçalho Retry-After, quando presente
+}
+
+func (e *CFError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("erro desconhecido da Cloudflare (HTTP %d)", e.Status)
+	}
+	parts := make([]string, len(e.Errors))
+	for i, d := range e.Errors {
+		parts[i] = fmt.Sprintf("%s (código %d)", d.Message, d.Code)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// parseRetryAfter aceita os dois formatos do cabeçalho: segundos ou data HTTP.
+// Retorna 0 quando ausente ou inválido; o teto evita travar um handler por minutos.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > time.Minute {
+		return time.Minute
+	}
+	return d
+}
+
+// isTransientCFError indica falhas que valem nova tentativa: erros de rede,
+// 429 e 5xx. Erros 4xx (token, payload) não mudam tentando de novo.
+func isTransientCFError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var cfErr *CFError
+	if errors.As(err, &cfErr) {
+		return cfErr.Status == http.StatusTooManyRequests || cfErr.Status >= 500
+	}
+	return false
+}
+
+func callCFAPI(ctx context.Context, method, url string, body interface{}) (CFRule, error) {
+	var rule CFRule
+	err := callCFAPIInto(ctx, method, url, body, &rule)
+	return rule, err
+}
+
+// callCFAPIInto faz a chamada e decodifica o campo result em out (se não for nil)
+func callCFAPIInto(ctx context.Context, method, url string, body, out interface{}) error {
+	_, err := callCFAPIPage(ctx, method, url, body, out)
+	return err
+}
+
+// callCFAPIPage é como callCFAPIInto, mas também retorna a paginação. Falhas
+// transitórias (rede, 429, 5xx) são repetidas até CF_MAX_ATTEMPTS vezes com
+// backoff exponencial e jitter; erros 4xx retornam na hora.
+func callCFAPIPage(ctx context.Context, method, url string, body, out interface{}) (CFResultInfo, error) {
+	return retryCFRequest(ctx, method, func() (CFResultInfo, error) {
+		return doCFRequest(ctx, method, url, body, out)
+	})
+}
+
+// retryCFRequest repete uma tentativa de chamada à Cloudflare conforme
+// CF_MAX_ATTEMPTS e o Retry-After, registrando métricas e erros. Serve também
+// às chamadas fora do formato padrão da API, como o GraphQL de analytics.
+func retryCFRequest(ctx context.Context, method string, try func() (CFResultInfo, error)) (info CFResultInfo, err error) {
+	start := time.Now()
+	defer func() { observeCFRequest(method, start, err) }()
+	attempts := cfMaxAttempts
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		info, err := try()
+		if err == nil {
+			return info, nil
+		}
+		// Requisição cancelada (cliente desconectou, encerramento): não é uma
+		// falha da Cloudflare e não adianta repetir
+		if ctx.Err() != nil {
+			return info, err
+		}
+		recordCFError(method, err)
+		if attempt >= attempts || !isTransientCFError(err) {
+			return info, err
+		}
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
+		var cfErr *CFError
+		if errors.As(err, &cfErr) && cfErr.RetryAfter > 0 {
+			wait = cfErr.RetryAfter
+		}
+		slog.Warn("Falha transitória na Cloudflare, nova tentativa", "method", method, "attempt", attempt, "max_attempts", attempts, "backoff", wait.Round(time.Millisecond).String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return info, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func doCFRequest(ctx context.Context, method, url string, body, out interface{}) (CFResultInfo, error) {
+	if cfDryRun {
+		return CFResultInfo{}, errCFDryRun
+	}
+	var bodyReader io.Reader
+	if body != nil {
+		jsonBytes, _ := json.Marshal(body)
+		bodyReader = bytes.NewBuffer(jsonBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	if err != nil {
+		return CFResultInfo{}, err
+	}
+	setCFHeaders(req)
+
+	resp, err := cfHTTPClient.Do(req)
+	if err != nil {
+		return CFResultInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return CFResultInfo{}, &CFError{
+			Status:     resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Message:    "limite de requisições da Cloudflare atingido (HTTP 429)",
+		}
+	}
+
+	// Corpo que não é JSON (ex.: página HTML de erro 5xx do gateway) vira um
+	// erro com o status e um trecho do corpo, em vez de um CFResponse vazio
+	var cfResp CFResponse
+	if err := json.Unmarshal(respBytes, &cfResp); err != nil {
+		snippet := string(respBytes)
+		if len(snippet) > 200 {
+			snippet = snippet[:200] + "..."
+		}
+		return CFResultInfo{}, &CFError{Status: resp.StatusCode, Message: fmt.Sprintf("resposta inválida da Cloudflare (HTTP %d): %s", resp.StatusCode, snippet)}
+	}
+
+	// Um DELETE que dá 404 significa que a regra já não existe: é o resultado desejado
+	if !cfResp.Success && !(method == "DELETE" && resp.StatusCode == http.StatusNotFound) {
+		cfErr := &CFError{Status: resp.StatusCode, Errors: cfResp.Errors}
+		for _, e := range cfResp.Errors {
+			if cfInvalidZoneCodes[e.Code] {
+				cfErr.Message = fmt.Sprintf("CF_ZONE_ID %q inválido ou inacessível com este token; confira o Zone ID na visão geral do domínio no painel da Cloudflare (código %d: %s)",
+					os.Getenv("CF_ZONE_ID"), e.Code, e.Message)
+			}
+		}
+		return CFResultInfo{}, cfErr
+	}
+
+	if out != nil && len(cfResp.Result) > 0 && string(cfResp.Result) != "null" {
+		if err := json.Unmarshal(cfResp.Result, out); err != nil {
+			return CFResultInfo{}, fmt.Errorf("resultado inesperado da Cloudflare: %v", err)
+		}
+	}
+	return cfResp.ResultInfo, nil
+}
+
+// Prefixos gerados: ALIAS_LENGTH caracteres (padrão 8, entre 4 e 32) de
+// ALIAS_CHARSET (padrão a-z0-9). Sempre há ao menos uma letra, pois alguns
+// provedores recusam endereços com a parte local só de números.
+var (
+	aliasLength  = 8
+	aliasCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+const (
+	minAliasLength = 4
+	maxAliasLength = 32 // o mesmo limite dos prefixos escolhidos (prefixPattern)
+)
+
+func initAliasGenerator() {
+	aliasLength = envInt("ALIAS_LENGTH", aliasLength)
+	if aliasLength < minAliasLength || aliasLength > maxAliasLength {
+		fatal(fmt.Sprintf("ALIAS_LENGTH deve estar entre %d e %d", minAliasLength, maxAliasLength), "value", aliasLength)
+	}
+	if raw, ok := os.LookupEnv("ALIAS_CHARSET"); ok {
+		// Sem ponto: "a..b" ou ".ab" não são partes locais válidas
+		seen := map[rune]bool{}
+		var charset []rune
+		for _, c := range strings.ToLower(raw) {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				fatal("ALIAS_CHARSET aceita apenas a-z, 0-9, - e _", "value", raw)
+			}
+			if !seen[c] {
+				seen[c] = true
+				charset = append(charset, c)
+			}
+		}
+		if !strings.ContainsAny(string(charset), "abcdefghijklmnopqrstuvwxyz") {
+			fatal("ALIAS_CHARSET precisa de ao menos uma letra", "value", raw)
+		}
+		aliasCharset = string(charset)
+	}
+}
+
+// generateAliasPrefix sorteia até sair um prefixo com letra; com o charset
+// padrão e 8 caracteres a chance de repetir é de cerca de 1 em 28 mil
+func generateAliasPrefix() string {
+	for {
+		prefix := generateRandomString(aliasLength, aliasCharset)
+		if strings.ContainsAny(prefix, "abcdefghijklmnopqrstuvwxyz") {
+			return prefix
+		}
+	}
+}
+
+func generateRandomString(n int, letters string) string {
+	// crypto/rand: prefixos imprevisíveis, para ninguém adivinhar um alias ativo
+	size := big.NewInt(int64(len(letters)))
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := crand.Int(crand.Reader, size)
+		if err != nil {
+			panic(fmt.Sprintf("crypto/rand indisponível: %v", err))
+		}
+		b[i] = letters[idx.Int64()]
 	}
 	return string(b)
-}
\ No newline at end of file
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEvents fica no lugar de events durante todos os testes: os eventos
+// publicados em background por um teste ainda podem chegar depois que ele
+// termina, então os testes trocam só o destino, protegido pelo mutex
+var testEvents = &switchPublisher{}
+
+type switchPublisher struct {
+	mu     sync.Mutex
+	target EventPublisher
+}
+
+func (p *switchPublisher) Publish(ev LifecycleEvent) error {
+	p.mu.Lock()
+	target := p.target
+	p.mu.Unlock()
+	if target == nil {
+		return nil
+	}
+	return target.Publish(ev)
+}
+
+func (p *switchPublisher) targets() []EventPublisher {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.target == nil {
+		return nil
+	}
+	return publishTargets(p.target)
+}
+
+// use troca o destino até o fim do teste
+func (p *switchPublisher) use(t testing.TB, target EventPublisher) {
+	p.mu.Lock()
+	p.target = target
+	p.mu.Unlock()
+	t.Cleanup(func() {
+		p.mu.Lock()
+		p.target = nil
+		p.mu.Unlock()
+	})
+}
+
+func TestMain(m *testing.M) {
+	events = testEvents
+	os.Exit(m.Run())
+}
+
+// fakeCF imita a parte da API de Email Routing usada pelo app. failures faz as
+// próximas chamadas de um método falharem com HTTP 500.
+type fakeCF struct {
+	mu       sync.Mutex
+	rules    map[string]CFRule
+	nextID   int
+	failures map[string]int
+	calls    []string
+	srv      *httptest.Server
+
+	// onRequest roda no meio de cada chamada, como se a Cloudflare demorasse
+	onRequest func(r *http.Request)
+}
+
+func (f *fakeCF) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	if f.onRequest != nil {
+		f.onRequest(r)
+	}
+
+	reply := func(status int, result interface{}) {
+		body := map[string]interface{}{"success": status < 300, "result": result, "errors": []CFErrorDetail{}}
+		if status >= 300 {
+			body["errors"] = []CFErrorDetail{{Code: 10000, Message: fmt.Sprintf("falha simulada (HTTP %d)", status)}}
+		}
+		if list, ok := result.([]CFRule); ok {
+			body["result_info"] = CFResultInfo{Page: 1, PerPage: len(list), TotalPages: 1, TotalCount: len(list)}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+	if f.failures[r.Method] > 0 {
+		f.failures[r.Method]--
+		reply(http.StatusInternalServerError, nil)
+		return
+	}
+
+	id, _ := strings.CutPrefix(r.URL.Path, "/zones/z/email/routing/rules")
+	id = strings.TrimPrefix(id, "/")
+	var req CFRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		list := []CFRule{}
+		for _, rule := range f.rules {
+			if rule.ID != "catch_all" {
+				list = append(list, rule)
+			}
+		}
+		reply(http.StatusOK, list)
+	case r.Method == http.MethodPost && id == "":
+		f.nextID++
+		rule := CFRule{ID: fmt.Sprintf("rule%d", f.nextID), Tag: fmt.Sprintf("tag%d", f.nextID), Name: req.Name, Enabled: req.Enabled, Matchers: req.Matchers, Actions: req.Actions}
+		f.rules[rule.ID] = rule
+		reply(http.StatusOK, rule)
+	case r.Method == http.MethodPut && id == "catch_all":
+		rule := CFRule{ID: "catch_all", Name: req.Name, Enabled: req.Enabled, Matchers: req.Matchers, Actions: req.Actions}
+		f.rules[id] = rule
+		reply(http.StatusOK, rule)
+	case r.Method == http.MethodGet:
+		if rule, ok := f.rules[id]; ok {
+			reply(http.StatusOK, rule)
+			return
+		}
+		reply(http.StatusNotFound, nil)
+	case r.Method == http.MethodPatch:
+		rule, ok := f.rules[id]
+		if !ok {
+			reply(http.StatusNotFound, nil)
+			return
+		}
+		rule.Enabled = req.Enabled
+		f.rules[id] = rule
+		reply(http.StatusOK, rule)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.rules[id]; !ok {
+			reply(http.StatusNotFound, nil)
+			return
+		}
+		delete(f.rules, id)
+		reply(http.StatusOK, CFRule{ID: id})
+	default:
+		reply(http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// rule devolve a regra guardada e se ela existe
+func (f *fakeCF) rule(id string) (CFRule, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rule, ok := f.rules[id]
+	return rule, ok
+}
+
+func (f *fakeCF) failNext(method string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = n
+}
+
+// setupTest abre um SQLite em memória com o schema de initDB e aponta o
+// cliente da Cloudflare para um fakeCF
+func setupTest(t testing.TB) *fakeCF {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	t.Setenv("DB_PATH", "file:"+name+"?mode=memory&cache=shared")
+	t.Setenv("DB_JOURNAL_MODE", "MEMORY")
+	t.Setenv("CF_ZONE_ID", "z")
+	t.Setenv("CF_API_TOKEN", "t")
+	t.Setenv("CF_EMAIL_DOMAIN", "x.com")
+	t.Setenv("CF_DESTINATION_EMAIL", "me@d.com")
+
+	stmtCache = map[string]*sql.Stmt{}
+	initDB()
+
+	f := &fakeCF{rules: map[string]CFRule{}, failures: map[string]int{}}
+	f.srv = httptest.NewServer(f)
+	oldURL, oldClient, oldAttempts := cfBaseURL, cfHTTPClient, cfMaxAttempts
+	cfBaseURL, cfHTTPClient, cfMaxAttempts = f.srv.URL, f.srv.Client(), 1
+
+	t.Cleanup(func() {
+		cfBaseURL, cfHTTPClient, cfMaxAttempts = oldURL, oldClient, oldAttempts
+		f.srv.Close()
+		for _, st := range stmtCache {
+			st.Close()
+		}
+		stmtCache = map[string]*sql.Stmt{}
+		db.Close()
+	})
+	return f
+}
+
+// emailStatus lê status e rule_id direto do banco
+func emailStatus(t testing.TB, id int64) (status, ruleID string) {
+	t.Helper()
+	if err := db.QueryRow("SELECT status, IFNULL(rule_id, '') FROM emails WHERE id = ?", id).Scan(&status, &ruleID); err != nil {
+		t.Fatalf("lendo email %d: %v", id, err)
+	}
+	return status, ruleID
+}
+
+// Um alias de TTL curto criado logo depois do início de um ciclo não entra
+// nesse ciclo, mas sai no primeiro ciclo cujo instante já passou de
+// expires_at, ou seja, no máximo um intervalo depois de vencer.
+func TestCleanupShortTTLCreatedMidCycle(t *testing.T) {
+	cf := setupTest(t)
+	ctx := context.Background()
+
+	oldMin := minTTL
+	minTTL = time.Second
+	t.Cleanup(func() { minTTL = oldMin })
+
+	// Já vencido antes do ciclo: sai neste ciclo
+	staleID, err := createEmail(ctx, GenerateRequest{Prefix: "stale", TTL: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cycleStart := time.Now()
+	if _, err := db.Exec("UPDATE emails SET expires_at = ? WHERE id = ?", cycleStart.Add(-time.Second), staleID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Criado depois do instante do ciclo, com TTL curto
+	shortID, err := createEmail(ctx, GenerateRequest{Prefix: "short", TTL: "2s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, shortRule := emailStatus(t, shortID)
+
+	const interval = time.Second
+	checkExpiredEmails(ctx, cycleStart)
+
+	if status, _ := emailStatus(t, staleID); status != "deleted" {
+		t.Errorf("alias vencido antes do ciclo: status %q, esperado deleted", status)
+	}
+	if status, _ := emailStatus(t, shortID); status != "active" {
+		t.Fatalf("alias criado depois do início do ciclo: status %q, esperado active", status)
+	}
+	if _, ok := cf.rule(shortRule); !ok {
+		t.Fatal("regra do alias novo removida no ciclo em que ele foi criado")
+	}
+
+	var expiresAt time.Time
+	if err := db.QueryRow("SELECT expires_at FROM emails WHERE id = ?", shortID).Scan(&expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ciclos seguintes, um por intervalo: o primeiro que começa depois de
+	// expires_at precisa removê-lo
+	for tick := cycleStart.Add(interval); ; tick = tick.Add(interval) {
+		checkExpiredEmails(ctx, tick)
+		status, _ := emailStatus(t, shortID)
+		if tick.Before(expiresAt) {
+			if status != "active" {
+				t.Fatalf("alias expirado em %s, antes de expires_at %s", tick, expiresAt)
+			}
+			continue
+		}
+		if status != "deleted" {
+			t.Fatalf("alias ainda %q no ciclo de %s, depois de expires_at %s", status, tick, expiresAt)
+		}
+		if lag := tick.Sub(expiresAt); lag > interval {
+			t.Errorf("expirado %s depois de expires_at, mais que um intervalo (%s)", lag, interval)
+		}
+		break
+	}
+	if _, ok := cf.rule(shortRule); ok {
+		t.Error("regra do alias expirado continua na Cloudflare")
+	}
+}
+
+// Pausar/reativar mantém banco e Cloudflare iguais mesmo quando um dos lados
+// falha, e não segura o lock de escrita do SQLite durante a chamada à Cloudflare
+func TestToggleConsistency(t *testing.T) {
+	cf := setupTest(t)
+	ctx := context.Background()
+
+	id, err := createEmail(ctx, GenerateRequest{Prefix: "toggle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idStr := fmt.Sprint(id)
+	_, ruleID := emailStatus(t, id)
+
+	assertState := func(step, wantStatus string) {
+		t.Helper()
+		status, _ := emailStatus(t, id)
+		var ruleEnabled bool
+		if err := db.QueryRow("SELECT rule_enabled FROM emails WHERE id = ?", id).Scan(&ruleEnabled); err != nil {
+			t.Fatal(err)
+		}
+		rule, ok := cf.rule(ruleID)
+		if !ok {
+			t.Fatalf("%s: regra %s sumiu da Cloudflare", step, ruleID)
+		}
+		if status != wantStatus {
+			t.Errorf("%s: status %q no banco, esperado %q", step, status, wantStatus)
+		}
+		if rule.Enabled != (wantStatus == "active") || ruleEnabled != rule.Enabled {
+			t.Errorf("%s: banco %s (rule_enabled=%v), Cloudflare enabled=%v", step, status, ruleEnabled, rule.Enabled)
+		}
+	}
+
+	// Outro escritor durante a chamada à Cloudflare não pode esbarrar no lock
+	var concurrentErr error
+	cf.onRequest = func(r *http.Request) {
+		if r.Method == http.MethodPatch {
+			_, concurrentErr = db.Exec("UPDATE emails SET note = 'concorrente' WHERE id = ?", id)
+		}
+	}
+	status, err := toggleEmail(ctx, idStr)
+	if err != nil || status != "inactive" {
+		t.Fatalf("pausar: status %q, erro %v", status, err)
+	}
+	if concurrentErr != nil {
+		t.Errorf("escrita concorrente durante a chamada à Cloudflare falhou: %v", concurrentErr)
+	}
+	cf.onRequest = nil
+	assertState("pausar", "inactive")
+
+	// Falha na Cloudflare: nada muda no banco
+	cf.failNext(http.MethodPatch, 1)
+	if _, err := toggleEmail(ctx, idStr); err == nil {
+		t.Fatal("reativar com a Cloudflare falhando: esperado erro")
+	}
+	assertState("falha na Cloudflare", "inactive")
+
+	// Falha no banco depois da Cloudflare: a regra volta ao estado anterior
+	if _, err := db.Exec(`CREATE TRIGGER fail_toggle BEFORE UPDATE OF status ON emails
+		BEGIN SELECT RAISE(ABORT, 'falha simulada'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toggleEmail(ctx, idStr); err == nil {
+		t.Fatal("reativar com o banco falhando: esperado erro")
+	}
+	assertState("falha no banco", "inactive")
+	if _, err := db.Exec("DROP TRIGGER fail_toggle"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = toggleEmail(ctx, idStr)
+	if err != nil || status != "active" {
+		t.Fatalf("reativar: status %q, erro %v", status, err)
+	}
+	assertState("reativar", "active")
+}
+
+// Pausar ou apagar o alias catch-all devolve o catch-all da zona à ação de
+// CATCH_ALL_ACTION/CATCH_ALL_DESTINATION, não a um drop fixo
+func TestCatchAllRemovalRestoresZoneAction(t *testing.T) {
+	cf := setupTest(t)
+	ctx := context.Background()
+
+	oldAction, oldDest := catchAllAction, catchAllDestination
+	catchAllAction, catchAllDestination = "forward", "zona@d.com"
+	t.Cleanup(func() { catchAllAction, catchAllDestination = oldAction, oldDest })
+
+	assertZoneAction := func(step, wantDest string) {
+		t.Helper()
+		rule, ok := cf.rule("catch_all")
+		if !ok {
+			t.Fatalf("%s: catch-all não configurado", step)
+		}
+		if !rule.Enabled || len(rule.Actions) != 1 || rule.Actions[0].Type != "forward" ||
+			len(rule.Actions[0].Value) != 1 || rule.Actions[0].Value[0] != wantDest {
+			t.Errorf("%s: catch-all %+v, esperado forward para %s", step, rule, wantDest)
+		}
+	}
+
+	id, err := createEmail(ctx, GenerateRequest{Matcher: matcherAll, Destination: "alias@d.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertZoneAction("criar", "alias@d.com")
+
+	idStr := fmt.Sprint(id)
+	if _, err := toggleEmail(ctx, idStr); err != nil {
+		t.Fatal(err)
+	}
+	assertZoneAction("pausar", "zona@d.com")
+	if _, err := toggleEmail(ctx, idStr); err != nil {
+		t.Fatal(err)
+	}
+	assertZoneAction("reativar", "alias@d.com")
+
+	if err := deleteEmail(ctx, idStr, ""); err != nil {
+		t.Fatal(err)
+	}
+	assertZoneAction("apagar", "zona@d.com")
+}
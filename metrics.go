@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Métricas Prometheus expostas em /metrics
+
+// expiryLag mede quanto tempo depois de expires_at o worker realmente removeu o email
+var expiryLag = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "expiry_lag_seconds",
+	Help:    "Atraso entre expires_at e a remoção efetiva do email pelo worker.",
+	Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+})
+
+var (
+	aliasesCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aliases_created_total",
+		Help: "Aliases criados.",
+	})
+	aliasesDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aliases_deleted_total",
+		Help: "Aliases excluídos manualmente.",
+	})
+	aliasesRenewed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aliases_renewed_total",
+		Help: "Renovações, manuais ou automáticas.",
+	})
+	aliasesExpired = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aliases_expired_total",
+		Help: "Aliases removidos pelo worker de expiração.",
+	})
+)
+
+// cfRollbacks conta regras apagadas na Cloudflare porque a linha não pôde ser
+// gravada; disco cheio aparece com reason="storage"
+var cfRollbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cf_rollbacks_total",
+	Help: "Regras criadas e removidas em seguida porque o banco recusou a linha, por motivo.",
+}, []string{"reason"})
+
+// Chamadas à API da Cloudflare, medidas em volta de callCFAPI (incluindo retries)
+var (
+	cfRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cf_api_requests_total",
+		Help: "Chamadas à API da Cloudflare por método e resultado (success/error).",
+	}, []string{"method", "outcome"})
+	cfRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cf_api_request_duration_seconds",
+		Help:    "Duração das chamadas à API da Cloudflare, incluindo retries.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// Gauge de aliases ativos, lido do banco a cada scrape
+var _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "aliases_active",
+	Help: "Aliases com status active no banco.",
+}, func() float64 {
+	var n int
+	if db == nil || db.QueryRow("SELECT COUNT(*) FROM emails WHERE status = 'active'").Scan(&n) != nil {
+		return 0
+	}
+	return float64(n)
+})
+
+// observeCFRequest registra uma chamada concluída à Cloudflare
+func observeCFRequest(method string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	cfRequests.WithLabelValues(method, outcome).Inc()
+	cfRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Nota livre por alias ("cadastro no trial da Acme"). O template escapa o
+// conteúdo; aqui só limitamos o tamanho e removemos caracteres de controle.
+
+const maxNoteLength = 500
+
+// cleanNote normaliza a nota, retornando false se passar do limite
+func cleanNote(note string) (string, bool) {
+	note = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(note))
+	return note, len([]rune(note)) <= maxNoteLength
+}
+
+// handleNote atualiza a nota de um email (POST /api/note?id=). A nota vem no
+// campo note do formulário ou, com Content-Type text/plain, no corpo inteiro.
+func handleNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	var raw string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain") {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 4*maxNoteLength+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		raw = string(body)
+	} else {
+		raw = r.FormValue("note")
+	}
+	note, ok := cleanNote(raw)
+	if !ok {
+		http.Error(w, "nota muito longa (máximo de 500 caracteres)", http.StatusBadRequest)
+		return
+	}
+
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := db.Exec("UPDATE emails SET note = ? WHERE id = ?", note, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "email não encontrado", http.StatusNotFound)
+		return
+	}
+
+	if wantsJSON(r) {
+		entry, err := getEmail(id)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
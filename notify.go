@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Aviso de expiração: com NOTIFY_BEFORE (ex.: 5m) o worker de limpeza publica
+// um evento "expiring" para cada alias ativo que vence dentro dessa janela, que
+// chega ao webhook, ao Redis e ao stream do painel como os demais eventos. A
+// coluna notified garante um único aviso por prazo; renovar ou recriar o alias
+// zera a coluna, e o novo prazo volta a ser avisado. Um destino que recusa o
+// aviso é tentado de novo nos ciclos seguintes sem repetir o envio aos demais. Aliases com auto-renovação
+// não são avisados, pois não vão expirar.
+var notifyBefore time.Duration
+
+// initNotify lê NOTIFY_BEFORE; roda antes de initEvents, que inclui "expiring"
+// nos eventos padrão do webhook quando o aviso está ligado
+func initNotify() {
+	v := os.Getenv("NOTIFY_BEFORE")
+	if v == "" {
+		return
+	}
+	d, err := parseTTL(v)
+	if err != nil {
+		fatal("NOTIFY_BEFORE inválido", "error", err)
+	}
+	notifyBefore = d
+	if os.Getenv("WEBHOOK_URL") == "" && os.Getenv("EVENTS_REDIS_URL") == "" {
+		slog.Warn("NOTIFY_BEFORE sem WEBHOOK_URL nem EVENTS_REDIS_URL: o aviso de expiração só chega ao painel")
+	}
+}
+
+func notifyExpiring(now time.Time) {
+	format := "2006-01-02 15:04:05"
+	until := now.Add(notifyBefore).UTC().Format(format)
+	rows, err := db.Query(`SELECT id, alias, expires_at FROM emails
+		WHERE status = 'active' AND IFNULL(notified, 0) = 0 AND IFNULL(auto_renew, 0) = 0
+		AND datetime(expires_at) > datetime(?) AND datetime(expires_at) <= datetime(?)`,
+		now.UTC().Format(format), until)
+	if err != nil {
+		slog.Error("Erro ao buscar emails perto de expirar", "error", err)
+		return
+	}
+	type expiring struct {
+		id        int
+		alias     string
+		expiresAt time.Time
+	}
+	var found []expiring
+	for rows.Next() {
+		var e expiring
+		if err := rows.Scan(&e.id, &e.alias, &e.expiresAt); err != nil {
+			slog.Error("Erro ao ler email perto de expirar", "error", err)
+			continue
+		}
+		found = append(found, e)
+	}
+	rows.Close()
+
+	// Marca um a um depois de fechar o cursor; só avisa quem foi marcado agora,
+	// o que cobre uma renovação entre a consulta e o UPDATE. A entrega roda em
+	// background para um webhook lento não segurar a limpeza.
+	var aliases []string
+	for _, e := range found {
+		res, err := db.Exec("UPDATE emails SET notified = 1 WHERE id = ? AND status = 'active' AND IFNULL(notified, 0) = 0 AND datetime(expires_at) <= datetime(?)", e.id, until)
+		if err != nil {
+			slog.Error("Erro ao marcar aviso de expiração", "id", e.id, "alias", e.alias, "error", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		expiringMu.Lock()
+		delete(expiringRetry, e.id)
+		expiringMu.Unlock()
+		ev := LifecycleEvent{Event: "expiring", ID: e.id, Alias: e.alias, Status: "active", Timestamp: time.Now().UTC()}
+		expiringDeliveries.Add(1)
+		go deliverExpiring(&expiringNotice{ev: ev, expiresAt: e.expiresAt, targets: publishTargets(events)})
+		aliases = append(aliases, e.alias)
+	}
+	if len(aliases) > 0 {
+		slog.Info("Aviso de expiração enviado", "count", len(aliases), "aliases", strings.Join(aliases, ","), "within", formatTTL(notifyBefore))
+	}
+
+	retryExpiring(now)
+}
+
+// expiringNotice é um aviso em entrega; targets são os destinos que ainda não
+// aceitaram o evento
+type expiringNotice struct {
+	ev        LifecycleEvent
+	expiresAt time.Time
+	targets   []EventPublisher
+	accepted  bool // algum destino já aceitou
+}
+
+var (
+	expiringMu sync.Mutex
+	// expiringRetry guarda, por id, os avisos aceitos por parte dos destinos;
+	// os demais são tentados de novo a cada ciclo
+	expiringRetry = map[int]*expiringNotice{}
+	// expiringDeliveries conta as entregas em andamento, esperadas no encerramento
+	expiringDeliveries sync.WaitGroup
+)
+
+// deliverExpiring publica o aviso em cada destino pendente. Se nenhum aceitou
+// a marca é desfeita e o próximo ciclo tenta de novo, como um aviso novo; se
+// algum aceitou o alias continua marcado e só os que recusaram são repetidos,
+// para quem já recebeu não receber duas vezes.
+func deliverExpiring(n *expiringNotice) {
+	defer expiringDeliveries.Done()
+	var failed []EventPublisher
+	for _, p := range n.targets {
+		if err := p.Publish(n.ev); err != nil {
+			slog.Error("Erro ao publicar aviso de expiração; nova tentativa no próximo ciclo", "id", n.ev.ID, "alias", n.ev.Alias, "error", err)
+			failed = append(failed, p)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	if !n.accepted && len(failed) == len(n.targets) {
+		if _, err := db.Exec("UPDATE emails SET notified = 0 WHERE id = ?", n.ev.ID); err != nil {
+			slog.Error("Erro ao desfazer marca de aviso de expiração", "id", n.ev.ID, "error", err)
+		}
+		return
+	}
+	n.targets, n.accepted = failed, true
+	expiringMu.Lock()
+	expiringRetry[n.ev.ID] = n
+	expiringMu.Unlock()
+}
+
+// retryExpiring reenvia os avisos pendentes aos destinos que recusaram. O aviso
+// é descartado quando o alias venceu ou deixou de estar marcado (renovado,
+// pausado ou apagado): o prazo avisado não vale mais.
+func retryExpiring(now time.Time) {
+	expiringMu.Lock()
+	pending := expiringRetry
+	expiringRetry = map[int]*expiringNotice{}
+	expiringMu.Unlock()
+
+	for id, n := range pending {
+		var one int
+		err := db.QueryRow("SELECT 1 FROM emails WHERE id = ? AND status = 'active' AND IFNULL(notified, 0) = 1", id).Scan(&one)
+		if err != nil || !now.Before(n.expiresAt) {
+			if err != nil && err != sql.ErrNoRows {
+				slog.Error("Erro ao conferir aviso de expiração pendente", "id", id, "error", err)
+			}
+			continue
+		}
+		expiringDeliveries.Add(1)
+		go deliverExpiring(n)
+	}
+}
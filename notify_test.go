@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPublisher guarda os eventos aceitos e recusa enquanto fail > 0
+type recordingPublisher struct {
+	mu       sync.Mutex
+	fail     int
+	accepted []LifecycleEvent
+}
+
+func (p *recordingPublisher) Publish(ev LifecycleEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("destino fora do ar")
+	}
+	p.accepted = append(p.accepted, ev)
+	return nil
+}
+
+type publisherFunc func(LifecycleEvent) error
+
+func (f publisherFunc) Publish(ev LifecycleEvent) error { return f(ev) }
+
+// O aviso só fica marcado depois que um destino aceita o evento; uma falha
+// faz o próximo ciclo tentar de novo
+func TestNotifyExpiringRetriesRejectedEvents(t *testing.T) {
+	setupTest(t)
+	oldBefore := notifyBefore
+	notifyBefore = 10 * time.Minute
+	t.Cleanup(func() { notifyBefore = oldBefore })
+	pub := &recordingPublisher{fail: 1}
+	testEvents.use(t, pub)
+
+	// Inserido direto: createEmail publicaria "created" em background e o
+	// evento poderia consumir a falha simulada
+	res, err := db.Exec("INSERT INTO emails (alias, rule_id, status, expires_at) VALUES ('aviso@x.com', 'r1', 'active', ?)", time.Now().Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	notified := func() bool {
+		var n bool
+		if err := db.QueryRow("SELECT IFNULL(notified, 0) FROM emails WHERE id = ?", id).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	notifyExpiring(time.Now())
+	expiringDeliveries.Wait()
+	if notified() {
+		t.Fatal("marcado como avisado com o evento recusado")
+	}
+
+	for i := 0; i < 2; i++ {
+		notifyExpiring(time.Now())
+		expiringDeliveries.Wait()
+	}
+	if !notified() {
+		t.Fatal("não marcado depois do evento aceito")
+	}
+	if len(pub.accepted) != 1 || pub.accepted[0].Event != "expiring" || pub.accepted[0].ID != int(id) {
+		t.Errorf("eventos aceitos: %+v, esperado um expiring para o id %d", pub.accepted, id)
+	}
+}
+
+// Com um destino recusando, o outro recebe o aviso uma única vez e só o que
+// recusou é tentado de novo; um destino travado não segura o ciclo
+func TestNotifyExpiringRetriesOnlyFailedDestinations(t *testing.T) {
+	setupTest(t)
+	oldBefore := notifyBefore
+	notifyBefore = 10 * time.Minute
+	t.Cleanup(func() { notifyBefore = oldBefore })
+	ok, flaky := &recordingPublisher{}, &recordingPublisher{fail: 2}
+	release := make(chan struct{})
+	stuck := publisherFunc(func(LifecycleEvent) error { <-release; return nil })
+	testEvents.use(t, multiPublisher{ok, flaky, stuck})
+
+	res, err := db.Exec("INSERT INTO emails (alias, rule_id, status, expires_at) VALUES ('aviso@x.com', 'r1', 'active', ?)", time.Now().Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+
+	done := make(chan struct{})
+	go func() {
+		notifyExpiring(time.Now())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("notifyExpiring esperou o destino travado")
+	}
+	close(release)
+	expiringDeliveries.Wait()
+
+	for i := 0; i < 3; i++ {
+		notifyExpiring(time.Now())
+		expiringDeliveries.Wait()
+	}
+	var notified bool
+	if err := db.QueryRow("SELECT IFNULL(notified, 0) FROM emails WHERE id = ?", id).Scan(&notified); err != nil || !notified {
+		t.Fatalf("notified = %v (erro %v), esperado marcado", notified, err)
+	}
+	if len(ok.accepted) != 1 {
+		t.Errorf("destino que aceitou recebeu %d avisos, esperado 1", len(ok.accepted))
+	}
+	if len(flaky.accepted) != 1 {
+		t.Errorf("destino que recusou recebeu %d avisos depois de voltar, esperado 1", len(flaky.accepted))
+	}
+}
+
+func TestWebhookDefaultEventsIncludeExpiring(t *testing.T) {
+	oldBefore := notifyBefore
+	t.Cleanup(func() { notifyBefore = oldBefore })
+
+	notifyBefore = 0
+	if newWebhookPublisher("http://exemplo").events["expiring"] {
+		t.Error("expiring nos eventos padrão sem NOTIFY_BEFORE")
+	}
+	notifyBefore = 5 * time.Minute
+	if !newWebhookPublisher("http://exemplo").events["expiring"] {
+		t.Error("expiring fora dos eventos padrão com NOTIFY_BEFORE")
+	}
+	t.Setenv("WEBHOOK_EVENTS", "created")
+	if newWebhookPublisher("http://exemplo").events["expiring"] {
+		t.Error("WEBHOOK_EVENTS explícito deve ser respeitado")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// Pagination descreve a página atual da listagem e monta os links de navegação
+type Pagination struct {
+	Page       int
+	PerPage    int
+	Total      int
+	TotalPages int
+	query      url.Values // filtros da requisição, preservados nos links
+}
+
+// parsePagination lê page e per_page (1 e 50 por padrão)
+func parsePagination(q url.Values) (Pagination, error) {
+	p := Pagination{Page: 1, PerPage: defaultPerPage, query: q}
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("page inválido: %s", v)
+		}
+		p.Page = n
+	}
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			return p, fmt.Errorf("per_page deve estar entre 1 e %d", maxPerPage)
+		}
+		p.PerPage = n
+	}
+	return p, nil
+}
+
+// Offset é o OFFSET SQL da página atual
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// setTotal registra o total de registros e calcula o número de páginas
+func (p *Pagination) setTotal(total int) {
+	p.Total = total
+	p.TotalPages = (total + p.PerPage - 1) / p.PerPage
+}
+
+func (p Pagination) HasPrev() bool { return p.Page > 1 }
+func (p Pagination) HasNext() bool { return p.Page < p.TotalPages }
+
+// URL monta o link para outra página mantendo os demais parâmetros
+func (p Pagination) URL(page int) string {
+	q := url.Values{}
+	for k, v := range p.query {
+		q[k] = v
+	}
+	q.Set("page", strconv.Itoa(page))
+	if p.PerPage != defaultPerPage {
+		q.Set("per_page", strconv.Itoa(p.PerPage))
+	}
+	return "/?" + q.Encode()
+}
+
+func (p Pagination) PrevURL() string { return p.URL(p.Page - 1) }
+func (p Pagination) NextURL() string { return p.URL(p.Page + 1) }
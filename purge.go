@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Purge: linhas deletadas ou expiradas só mudam de status e se acumulam para
+// sempre. Com PURGE_AFTER (ex.: 30d) o worker de limpeza as remove de vez
+// depois desse tempo, contado da exclusão ou do vencimento; /api/purge faz o
+// mesmo sob demanda. Um alias expirado e já removido não pode mais ser recriado.
+var purgeAfter time.Duration
+
+// purgeWhere seleciona as linhas deletadas ou expiradas há mais de um corte. Linhas com
+// rule_id nunca são apagadas: perderíamos o rastro de uma regra ainda viva na
+// Cloudflare.
+const purgeWhere = `WHERE status IN ('deleted', 'expired') AND IFNULL(rule_id, '') = ''
+	AND datetime(IFNULL(deleted_at, expires_at)) <= datetime(?)`
+
+func purgeCutoff(age time.Duration) string {
+	return time.Now().Add(-age).UTC().Format("2006-01-02 15:04:05")
+}
+
+// purgeOld apaga linhas deletadas ou expiradas há mais de age
+func purgeOld(age time.Duration) (int64, error) {
+	res, err := db.Exec("DELETE FROM emails "+purgeWhere, purgeCutoff(age))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeCandidate é uma linha que o purge apagaria (dry_run)
+type PurgeCandidate struct {
+	ID    int    `json:"id"`
+	Alias string `json:"alias"`
+}
+
+// purgeCandidates lista, sem apagar, as linhas que purgeOld removeria
+func purgeCandidates(age time.Duration) ([]PurgeCandidate, error) {
+	rows, err := db.Query("SELECT id, alias FROM emails "+purgeWhere+" ORDER BY id", purgeCutoff(age))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []PurgeCandidate{}
+	for rows.Next() {
+		var c PurgeCandidate
+		if err := rows.Scan(&c.ID, &c.Alias); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// handlePurge (POST) aceita older_than (ex.: 7d, 720h); sem ele usa PURGE_AFTER.
+// Com dry_run=true só lista as linhas que seriam apagadas.
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+
+	age := purgeAfter
+	if v := r.FormValue("older_than"); v != "" {
+		d, err := parseTTL(v)
+		if err != nil {
+			writeJSONError(w, errorf(http.StatusBadRequest, "older_than: %v", err))
+			return
+		}
+		age = d
+	}
+	if age <= 0 {
+		writeJSONError(w, errorf(http.StatusBadRequest, "informe older_than ou configure PURGE_AFTER"))
+		return
+	}
+
+	if r.FormValue("dry_run") == "true" {
+		list, err := purgeCandidates(age)
+		if err != nil {
+			writeJSONError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"dry_run": true, "would_purge": len(list), "emails": list, "older_than": formatTTL(age)})
+		return
+	}
+
+	n, err := purgeOld(age)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"purged": n, "older_than": formatTTL(age)})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR code do endereço para copiar no celular: /api/qr?id= devolve um SVG.
+// A codificação fica com github.com/skip2/go-qrcode (correção de erro nível M);
+// aqui só o bitmap vira SVG, que escala sem borrar em qualquer tela.
+
+// qrSVG desenha o bitmap, que já inclui a zona de silêncio
+func qrSVG(bitmap [][]bool) string {
+	dim := len(bitmap)
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x, y)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`, dim, dim, path.String())
+}
+
+// handleQR devolve o QR code (SVG) com o endereço do alias
+func handleQR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	entry, err := getEmail(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	code, err := qrcode.New(entry.Alias, qrcode.Medium)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// O endereço de um id nunca muda, então o navegador pode guardar a imagem
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	fmt.Fprint(w, qrSVG(code.Bitmap()))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+func TestHandleQR(t *testing.T) {
+	setupTest(t)
+	id, err := createEmail(context.Background(), GenerateRequest{Prefix: "qr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleQR(w, httptest.NewRequest(http.MethodGet, "/api/qr?"+query, nil))
+		return w
+	}
+
+	w := get(fmt.Sprintf("id=%d", id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age=") {
+		t.Errorf("Cache-Control %q", cc)
+	}
+
+	// O SVG desenha exatamente os módulos escuros do código do alias
+	code, err := qrcode.New("qr@x.com", qrcode.Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitmap := code.Bitmap()
+	dark := 0
+	for _, row := range bitmap {
+		for _, d := range row {
+			if d {
+				dark++
+			}
+		}
+	}
+	body := w.Body.String()
+	if n := strings.Count(body, "h1v1h-1z"); n != dark {
+		t.Errorf("%d módulos no SVG, esperado %d", n, dark)
+	}
+	if viewBox := fmt.Sprintf(`viewBox="0 0 %d %d"`, len(bitmap), len(bitmap)); !strings.Contains(body, viewBox) {
+		t.Errorf("SVG sem %s", viewBox)
+	}
+
+	if w := get("id=999"); w.Code != http.StatusNotFound {
+		t.Errorf("id inexistente: HTTP %d, esperado 404", w.Code)
+	}
+	if w := get("id=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("id inválido: HTTP %d, esperado 400", w.Code)
+	}
+}
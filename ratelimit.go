@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limite de criação por cliente: RATE_LIMIT pedidos por minuto por cliente
+// (usuário autenticado ou IP, ver clientIdentity), em um token bucket com
+// capacidade igual ao próprio limite (permite rajadas curtas). Buckets parados
+// há tempo suficiente para encher de novo são descartados. Desabilitado quando
+// RATE_LIMIT é 0 ou vazio. Requisições sem identidade (socket Unix sem
+// X-Forwarded-For) não são limitadas: um bucket único juntaria todos os
+// usuários e um cliente abusivo bloquearia os demais.
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	rateLimit     int // pedidos por minuto
+	rateMu        sync.Mutex
+	rateBuckets   = map[string]*tokenBucket{}
+	rateLastSweep time.Time
+	rateNoIDOnce  sync.Once
+)
+
+func initRateLimit() {
+	rateLimit = envInt("RATE_LIMIT", 0)
+}
+
+// allowRequest consome um token do cliente; quando não há token devolve
+// quanto tempo falta para o próximo
+func allowRequest(key string, now time.Time) (bool, time.Duration) {
+	capacity := float64(rateLimit)
+	perSecond := capacity / 60
+
+	rateMu.Lock()
+	defer rateMu.Unlock()
+
+	// Limpeza periódica para o mapa não crescer indefinidamente: um bucket
+	// parado há mais de um minuto já está cheio e equivale a um novo
+	if now.Sub(rateLastSweep) > time.Minute {
+		for k, b := range rateBuckets {
+			if now.Sub(b.last) > time.Minute {
+				delete(rateBuckets, k)
+			}
+		}
+		rateLastSweep = now
+	}
+
+	b, ok := rateBuckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		rateBuckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+}
+
+// rateLimited aplica o limite ao handler e responde 429 com Retry-After
+func rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rateLimit <= 0 {
+			next(w, r)
+			return
+		}
+		key := clientIdentity(r)
+		if key == "" {
+			rateNoIDOnce.Do(func() {
+				slog.Warn("RATE_LIMIT sem efeito para requisições sem IP do cliente; configure o proxy para enviar X-Forwarded-For")
+			})
+			next(w, r)
+			return
+		}
+		ok, wait := allowRequest(key, time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			err := errorf(http.StatusTooManyRequests, "limite de %d criações por minuto excedido", rateLimit)
+			if wantsJSON(r) || r.URL.Path == "/api/v1/generate" {
+				writeJSONError(w, err)
+			} else {
+				writeError(w, err)
+			}
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// O limite é por cliente: cada usuário autenticado tem o seu bucket, mesmo
+// atrás do mesmo IP, e requisições sem identidade não dividem um bucket
+func TestRateLimitPerClientIdentity(t *testing.T) {
+	t.Setenv("APP_AUTH_TOKEN", "segredo")
+	oldLimit := rateLimit
+	rateLimit = 1
+	rateBuckets = map[string]*tokenBucket{}
+	trustedProxies = nil
+	t.Cleanup(func() {
+		rateLimit = oldLimit
+		rateBuckets = map[string]*tokenBucket{}
+	})
+
+	handler := rateLimited(func(w http.ResponseWriter, r *http.Request) {})
+	call := func(remote, user string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+		r.RemoteAddr = remote
+		if user != "" {
+			r.SetBasicAuth(user, "segredo")
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if code := call("203.0.113.7:1", "ana"); code != http.StatusOK {
+		t.Fatalf("primeira criação de ana: HTTP %d", code)
+	}
+	if code := call("203.0.113.7:2", "ana"); code != http.StatusTooManyRequests {
+		t.Errorf("segunda criação de ana: HTTP %d, esperado 429", code)
+	}
+	if code := call("203.0.113.7:3", "bia"); code != http.StatusOK {
+		t.Errorf("bia no mesmo IP: HTTP %d, esperado 200", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := call("@", ""); code != http.StatusOK {
+			t.Errorf("socket Unix sem identidade, chamada %d: HTTP %d, esperado 200", i+1, code)
+		}
+	}
+}
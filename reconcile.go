@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reconciliação entre o banco e as regras da Cloudflare. O último resultado
+// fica em memória e é exposto em /api/reconcile/status; /api/sync devolve o
+// diff completo.
+
+type ReconcileReport struct {
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Matched      int       `json:"matched"`
+	OrphanedInCF int       `json:"orphaned_in_cf"` // regras TempMail- sem linha no banco
+	MissingInCF  int       `json:"missing_in_cf"`  // linhas com rule_id que não existe na Cloudflare
+	Corrected    int       `json:"corrected"`
+	Error        string    `json:"error,omitempty"`
+
+	// Detalhes do diff
+	Orphans []ReconcileItem `json:"orphans"` // regras TempMail- sem linha no banco
+	Missing []ReconcileItem `json:"missing"` // linhas cuja regra sumiu da Cloudflare
+}
+
+// ReconcileItem identifica um lado da divergência
+type ReconcileItem struct {
+	RuleID  string `json:"rule_id"`
+	Name    string `json:"name,omitempty"`     // nome da regra na Cloudflare
+	EmailID int    `json:"email_id,omitempty"` // linha no banco
+	Alias   string `json:"alias,omitempty"`
+	Fixed   bool   `json:"fixed"`
+}
+
+// reconcileMu só serializa as execuções, que podem demorar (listagem paginada
+// com retries); o último relatório fica à parte para /api/reconcile/status
+// responder durante uma execução
+var (
+	reconcileMu   sync.Mutex
+	lastReconcile atomic.Pointer[ReconcileReport]
+)
+
+// runReconcile compara as regras e, com fix=true, apaga regras órfãs na
+// Cloudflare e marca como deletadas as linhas cuja regra sumiu
+func runReconcile(ctx context.Context, fix bool) (report ReconcileReport) {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	report = ReconcileReport{StartedAt: time.Now(), Orphans: []ReconcileItem{}, Missing: []ReconcileItem{}}
+	defer func() {
+		report.FinishedAt = time.Now()
+		saved := report
+		lastReconcile.Store(&saved)
+	}()
+
+	// O banco é lido antes da listagem: uma linha criada no meio já tem a
+	// regra na Cloudflare e não pode aparecer como "sumiu de lá"
+	inDB, err := reconcileRows()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	rules, err := listCFRules(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	inCF := map[string]CFRule{}
+	for _, rule := range rules {
+		inCF[rule.ID] = rule
+	}
+
+	// Uma regra criada entre a leitura e a listagem só tem linha na segunda
+	// leitura: órfã é a regra que não aparece em nenhuma das duas. Do mesmo
+	// jeito, uma linha que perdeu a regra nesse meio tempo (delete, expiração)
+	// não está mais na segunda leitura e não conta como divergência.
+	after, err := reconcileRows()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	for ruleID, item := range inDB {
+		if _, ok := inCF[ruleID]; ok {
+			report.Matched++
+			continue
+		}
+		if _, ok := after[ruleID]; !ok {
+			continue
+		}
+		report.MissingInCF++
+		if fix {
+			// rule_id no WHERE: a linha pode ter sido recriada com outra regra
+			res, err := db.Exec("UPDATE emails SET status = 'deleted', rule_id = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND rule_id = ?", item.EmailID, ruleID)
+			if err != nil {
+				slog.Error("Erro ao marcar linha sem regra", "id", item.EmailID, "rule_id", ruleID, "error", err)
+			} else if n, _ := res.RowsAffected(); n > 0 {
+				item.Fixed = true
+				report.Corrected++
+			}
+		}
+		report.Missing = append(report.Missing, item)
+	}
+
+	for ruleID, rule := range inCF {
+		_, before := inDB[ruleID]
+		_, now := after[ruleID]
+		if before || now || !strings.HasPrefix(rule.Name, "TempMail-") || rule.Name == "TempMail-catch-all" {
+			continue
+		}
+		report.OrphanedInCF++
+		item := ReconcileItem{RuleID: ruleID, Name: rule.Name}
+		if fix {
+			if err := deleteCFRule(ctx, ruleID); err != nil {
+				slog.Error("Erro ao remover regra órfã", "rule_id", ruleID, "error", err)
+			} else {
+				item.Fixed = true
+				report.Corrected++
+			}
+		}
+		report.Orphans = append(report.Orphans, item)
+	}
+
+	slog.Info("Reconciliação concluída", "matched", report.Matched, "orphaned_in_cf", report.OrphanedInCF,
+		"missing_in_cf", report.MissingInCF, "corrected", report.Corrected)
+	return report
+}
+
+// reconcileRows lê as linhas com regra, indexadas pelo rule_id. O catch-all
+// não aparece como regra comum, então fica fora da comparação.
+func reconcileRows() (map[string]ReconcileItem, error) {
+	rows, err := db.Query("SELECT id, rule_id, alias FROM emails WHERE IFNULL(rule_id, '') != '' AND IFNULL(matcher, 'literal') != 'all'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := map[string]ReconcileItem{}
+	for rows.Next() {
+		var item ReconcileItem
+		if err := rows.Scan(&item.EmailID, &item.RuleID, &item.Alias); err == nil {
+			items[item.RuleID] = item
+		}
+	}
+	return items, rows.Err()
+}
+
+// startReconcileWorker roda a reconciliação periodicamente se RECONCILE_INTERVAL estiver definido
+func startReconcileWorker(ctx context.Context, interval time.Duration, fix bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runReconcile(ctx, fix)
+		}
+	}
+}
+
+// handleReconcile dispara uma reconciliação sob demanda (POST, fix=true para corrigir)
+func handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	report := runReconcile(r.Context(), r.FormValue("fix") == "true")
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleSync devolve o diff entre o banco e a Cloudflare: GET só compara,
+// POST com fix=true também corrige (apaga regras TempMail- órfãs e marca como
+// deletadas as linhas cuja regra não existe mais)
+func handleSync(w http.ResponseWriter, r *http.Request) {
+	fix := false
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		fix = r.FormValue("fix") == "true"
+	default:
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	report := runReconcile(r.Context(), fix)
+	status := http.StatusOK
+	if report.Error != "" {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, report)
+}
+
+func handleReconcileStatus(w http.ResponseWriter, r *http.Request) {
+	report := lastReconcile.Load()
+	if report == nil {
+		http.Error(w, "nenhuma reconciliação executada ainda", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Um alias criado enquanto a reconciliação lista as regras não pode ser dado
+// como divergente, nem com fix=true
+func TestReconcileIgnoresRowsCreatedDuringListing(t *testing.T) {
+	cf := setupTest(t)
+	ctx := context.Background()
+
+	id, err := createEmail(ctx, GenerateRequest{Prefix: "antigo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simula duas criações de outras requisições no meio da listagem: em uma
+	// a regra já entra na lista, na outra ela só passa a existir depois
+	var created []int64
+	cf.onRequest = func(r *http.Request) {
+		if r.Method != http.MethodGet || created != nil {
+			return
+		}
+		cf.rules["rule-listada"] = CFRule{ID: "rule-listada", Name: "TempMail-listada@x.com", Enabled: true}
+		for _, row := range [][2]string{{"listada@x.com", "rule-listada"}, {"tardia@x.com", "rule-tardia"}} {
+			res, err := db.Exec("INSERT INTO emails (alias, rule_id, status, expires_at) VALUES (?, ?, 'active', datetime('now', '+1 hour'))", row[0], row[1])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			newID, _ := res.LastInsertId()
+			created = append(created, newID)
+		}
+	}
+
+	report := runReconcile(ctx, true)
+	if report.Error != "" {
+		t.Fatal(report.Error)
+	}
+	if len(report.Missing) != 0 || len(report.Orphans) != 0 {
+		t.Errorf("divergências inesperadas: missing=%+v orphans=%+v", report.Missing, report.Orphans)
+	}
+	if report.Matched != 1 {
+		t.Errorf("matched = %d, esperado 1", report.Matched)
+	}
+	for _, row := range append(created, id) {
+		if status, _ := emailStatus(t, row); status != "active" {
+			t.Errorf("linha %d: status %q depois da reconciliação, esperado active", row, status)
+		}
+	}
+	if _, ok := cf.rule("rule-listada"); !ok {
+		t.Error("regra do alias novo apagada como órfã")
+	}
+}
+
+// /api/reconcile/status responde com o último relatório enquanto outra
+// execução ainda está listando as regras
+func TestReconcileStatusDuringRun(t *testing.T) {
+	cf := setupTest(t)
+	ctx := context.Background()
+	runReconcile(ctx, false)
+
+	listing := make(chan struct{})
+	release := make(chan struct{})
+	cf.onRequest = func(r *http.Request) {
+		if r.Method == http.MethodGet {
+			close(listing)
+			<-release
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runReconcile(ctx, false)
+	}()
+	<-listing
+
+	status := make(chan int, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		handleReconcileStatus(w, httptest.NewRequest(http.MethodGet, "/api/reconcile/status", nil))
+		status <- w.Code
+	}()
+	select {
+	case code := <-status:
+		if code != http.StatusOK {
+			t.Errorf("status: HTTP %d, esperado 200", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("status travado enquanto a reconciliação roda")
+	}
+	close(release)
+	<-done
+}
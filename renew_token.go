@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+)
+
+// Tokens de renovação: permitem que uma automação renove um único email
+// sem credenciais amplas. O token é "<id>.<hmac>" assinado com
+// RENEW_TOKEN_SECRET; sem o segredo o recurso fica desabilitado.
+
+func renewTokenSecret() []byte {
+	return []byte(os.Getenv("RENEW_TOKEN_SECRET"))
+}
+
+func signRenewToken(id string) string {
+	mac := hmac.New(sha256.New, renewTokenSecret())
+	mac.Write([]byte("renew:" + id))
+	return id + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validRenewToken confere se o token foi emitido para o email informado
+func validRenewToken(token, id string) bool {
+	if len(renewTokenSecret()) == 0 {
+		return false
+	}
+	tokenID, _, ok := strings.Cut(token, ".")
+	if !ok || tokenID != id {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(signRenewToken(id)))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Stream ao vivo para o painel: /api/events é um endpoint Server-Sent Events
+// que repassa os eventos de ciclo de vida (o hub é mais um EventPublisher, então
+// recebe tudo que passa por publishEvent, inclusive as expirações do worker de
+// limpeza) e, a cada SSE_TICK_INTERVAL (padrão 5s), um "tick" com os emails
+// ativos e os segundos restantes de cada um.
+
+type sseMessage struct {
+	event string
+	data  []byte
+}
+
+// TickAlias é um email ativo no tick do stream
+type TickAlias struct {
+	ID               int       `json:"id"`
+	Alias            string    `json:"alias"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RemainingSeconds int       `json:"remaining_seconds"`
+}
+
+type sseHub struct {
+	mu      sync.Mutex
+	clients map[chan sseMessage]struct{}
+	done    chan struct{} // fechado no encerramento para liberar as conexões abertas
+}
+
+var liveEvents = &sseHub{clients: map[chan sseMessage]struct{}{}, done: make(chan struct{})}
+
+func (h *sseHub) subscribe() chan sseMessage {
+	ch := make(chan sseMessage, 16)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *sseHub) unsubscribe(ch chan sseMessage) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+func (h *sseHub) hasClients() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients) > 0
+}
+
+// broadcast nunca bloqueia: um cliente lento perde mensagens em vez de
+// atrasar os demais (o próximo tick corrige o estado)
+func (h *sseHub) broadcast(msg sseMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// Publish implementa EventPublisher
+func (h *sseHub) Publish(ev LifecycleEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	h.broadcast(sseMessage{event: ev.Event, data: data})
+	return nil
+}
+
+// run envia os ticks enquanto houver clientes conectados
+func (h *sseHub) run(ctx context.Context, interval time.Duration) {
+	defer close(h.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !h.hasClients() {
+				continue
+			}
+			msg, err := tickMessage(time.Now())
+			if err != nil {
+				slog.Error("Erro ao montar tick do stream", "error", err)
+				continue
+			}
+			h.broadcast(msg)
+		}
+	}
+}
+
+// tickMessage lista os emails ativos com o tempo restante
+func tickMessage(now time.Time) (sseMessage, error) {
+	rows, err := db.Query("SELECT id, alias, expires_at FROM emails WHERE status = 'active' AND expires_at IS NOT NULL ORDER BY expires_at")
+	if err != nil {
+		return sseMessage{}, err
+	}
+	defer rows.Close()
+
+	aliases := []TickAlias{}
+	for rows.Next() {
+		var a TickAlias
+		if err := rows.Scan(&a.ID, &a.Alias, &a.ExpiresAt); err != nil {
+			return sseMessage{}, err
+		}
+		if remaining := int(a.ExpiresAt.Sub(now).Seconds()); remaining > 0 {
+			a.RemainingSeconds = remaining
+		}
+		aliases = append(aliases, a)
+	}
+	if err := rows.Err(); err != nil {
+		return sseMessage{}, err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"aliases": aliases, "timestamp": now.UTC()})
+	return sseMessage{event: "tick", data: data}, err
+}
+
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming não suportado", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx não deve bufferizar o stream
+
+	ch := liveEvents.subscribe()
+	defer liveEvents.unsubscribe(ch)
+
+	send := func(msg sseMessage) {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.event, msg.data)
+		flusher.Flush()
+	}
+
+	// Estado inicial sem esperar o primeiro tick
+	if msg, err := tickMessage(time.Now()); err == nil {
+		send(msg)
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-liveEvents.done:
+			return
+		case msg := <-ch:
+			send(msg)
+		}
+	}
+}
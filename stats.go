@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Estatísticas agregadas para o painel e para /api/stats
+
+// Stats resume os emails por status e a atividade recente
+type Stats struct {
+	Active       int          `json:"active"`
+	Inactive     int          `json:"inactive"`
+	Expired      int          `json:"expired"`
+	Deleted      int          `json:"deleted"`
+	Failed       int          `json:"failed"`
+	TotalCreated int          `json:"total_created"` // inclui linhas já removidas pelo purge
+	ExpiringSoon int          `json:"expiring_soon"` // ativos que expiram nos próximos 10 minutos
+	CreatedByDay []DailyCount `json:"created_by_day"`
+	GeneratedAt  time.Time    `json:"generated_at"`
+}
+
+// DailyCount é o número de emails criados em um dia (UTC)
+type DailyCount struct {
+	Date  string `json:"date"` // AAAA-MM-DD
+	Count int    `json:"count"`
+}
+
+const (
+	expiringSoonWindow = 10 * time.Minute
+	statsDays          = 7
+)
+
+func loadStats(now time.Time) (Stats, error) {
+	s := Stats{GeneratedAt: now.UTC()}
+
+	rows, err := db.Query("SELECT status, COUNT(*) FROM emails GROUP BY status")
+	if err != nil {
+		return s, err
+	}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			rows.Close()
+			return s, err
+		}
+		switch status {
+		case "active":
+			s.Active = n
+		case "inactive":
+			s.Inactive = n
+		case "expired":
+			s.Expired = n
+		case "deleted":
+			s.Deleted = n
+		case "failed":
+			s.Failed = n
+		}
+	}
+	rows.Close()
+
+	// O AUTOINCREMENT guarda o maior id já usado, que continua valendo depois
+	// do purge; tabelas sem linhas ainda não têm entrada em sqlite_sequence
+	if err := db.QueryRow("SELECT IFNULL((SELECT seq FROM sqlite_sequence WHERE name = 'emails'), 0)").Scan(&s.TotalCreated); err != nil {
+		return s, err
+	}
+
+	format := "2006-01-02 15:04:05"
+	if err := db.QueryRow("SELECT COUNT(*) FROM emails WHERE status = 'active' AND datetime(expires_at) > datetime(?) AND datetime(expires_at) <= datetime(?)",
+		now.UTC().Format(format), now.Add(expiringSoonWindow).UTC().Format(format)).Scan(&s.ExpiringSoon); err != nil {
+		return s, err
+	}
+
+	// Criados por dia nos últimos 7 dias, com zero nos dias sem criação
+	first := now.UTC().AddDate(0, 0, -(statsDays - 1))
+	byDay := map[string]int{}
+	rows, err = db.Query("SELECT date(created_at), COUNT(*) FROM emails WHERE date(created_at) >= ? GROUP BY date(created_at)", first.Format("2006-01-02"))
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			return s, err
+		}
+		byDay[day] = n
+	}
+	for i := 0; i < statsDays; i++ {
+		day := first.AddDate(0, 0, i).Format("2006-01-02")
+		s.CreatedByDay = append(s.CreatedByDay, DailyCount{Date: day, Count: byDay[day]})
+	}
+	return s, rows.Err()
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, errorf(405, "Method not allowed"))
+		return
+	}
+	stats, err := loadStats(time.Now())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
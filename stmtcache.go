@@ -0,0 +1,31 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// Cache de prepared statements para as consultas recorrentes (listagem do
+// painel, busca por id e a varredura do worker de limpeza): cada SQL é
+// preparado uma vez e reusado. A chave é o texto da consulta, que só varia
+// com a combinação de filtros, então o cache fica pequeno.
+
+var (
+	stmtMu    sync.Mutex
+	stmtCache = map[string]*sql.Stmt{}
+)
+
+// prepared devolve o statement já preparado para a consulta
+func prepared(query string) (*sql.Stmt, error) {
+	stmtMu.Lock()
+	defer stmtMu.Unlock()
+	if st, ok := stmtCache[query]; ok {
+		return st, nil
+	}
+	st, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	stmtCache[query] = st
+	return st, nil
+}
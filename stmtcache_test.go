@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Comparação do cache de prepared statements com a preparação a cada chamada,
+// em um banco com benchEmails linhas:
+//
+//	go test -run '^$' -bench 'ListEmails|EmailsStatus|FindExpired' -benchmem
+
+const benchEmails = 5000
+
+func populateEmails(b *testing.B, n int) {
+	b.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	st, err := tx.Prepare("INSERT INTO emails (alias, rule_id, status, expires_at, ttl_seconds) VALUES (?, ?, ?, ?, 3600)")
+	if err != nil {
+		b.Fatal(err)
+	}
+	statuses := []string{"active", "inactive", "expired", "deleted"}
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		status := statuses[i%len(statuses)]
+		if _, err := st.Exec(fmt.Sprintf("bench%d@x.com", i), fmt.Sprintf("rule%d", i), status, now.Add(time.Duration(i)*time.Minute)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	st.Close()
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// resetStmtCache fecha e descarta os statements, como se não houvesse cache
+func resetStmtCache() {
+	stmtMu.Lock()
+	defer stmtMu.Unlock()
+	for _, st := range stmtCache {
+		st.Close()
+	}
+	stmtCache = map[string]*sql.Stmt{}
+}
+
+func BenchmarkListEmails(b *testing.B) {
+	setupTest(b)
+	populateEmails(b, benchEmails)
+	q := url.Values{"status": {"active"}}
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := listEmails(q); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resetStmtCache()
+			if _, _, err := listEmails(q); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// A consulta de /api/emails/status, com o máximo padrão de ids
+func BenchmarkEmailsStatus(b *testing.B) {
+	setupTest(b)
+	populateEmails(b, benchEmails)
+
+	ids := make([]interface{}, maxStatusIDs)
+	for i := range ids {
+		ids[i] = i*(benchEmails/maxStatusIDs) + 1
+	}
+	query := "SELECT " + emailColumns + " FROM emails WHERE id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+
+	scanAll := func(b *testing.B, rows *sql.Rows, err error) {
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer rows.Close()
+		n := 0
+		for rows.Next() {
+			if _, err := scanEmail(rows); err != nil {
+				b.Fatal(err)
+			}
+			n++
+		}
+		if n != len(ids) {
+			b.Fatalf("%d linhas, esperado %d", n, len(ids))
+		}
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			st, err := prepared(query)
+			if err != nil {
+				b.Fatal(err)
+			}
+			rows, err := st.Query(ids...)
+			scanAll(b, rows, err)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows, err := db.Query(query, ids...)
+			scanAll(b, rows, err)
+		}
+	})
+}
+
+// A varredura do worker de limpeza, que roda a cada CLEANUP_INTERVAL
+func BenchmarkFindExpiredEmails(b *testing.B) {
+	setupTest(b)
+	populateEmails(b, benchEmails)
+	now := time.Now().Add(24 * time.Hour)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			findExpiredEmails(now)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resetStmtCache()
+			findExpiredEmails(now)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Tags agrupam aliases por finalidade. Ficam na coluna tags separadas por
+// vírgula, já normalizadas (minúsculas, sem repetição).
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+const maxTags = 10
+
+// parseTags normaliza e valida tags vindas de campos de formulário ou JSON;
+// cada valor pode ainda conter várias tags separadas por vírgula
+func parseTags(values []string) ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if !tagPattern.MatchString(tag) {
+				return nil, fmt.Errorf("tag inválida: %q (use letras, números, _ ou -, até 32 caracteres)", tag)
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("máximo de %d tags por alias", maxTags)
+	}
+	return tags, nil
+}
+
+// splitTags lê o valor da coluna tags
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// handleTag substitui as tags de um email (POST /api/tag?id=, campo tags)
+func handleTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	r.ParseForm()
+	tags, err := parseTags(r.Form["tags"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := formID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := db.Exec("UPDATE emails SET tags = ? WHERE id = ?", strings.Join(tags, ","), id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "email não encontrado", http.StatusNotFound)
+		return
+	}
+
+	if wantsJSON(r) {
+		entry, err := getEmail(id)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"time"
+	_ "time/tzdata" // a imagem alpine não traz o banco de fusos
+)
+
+// Os templates vão embutidos no binário: não dependem do diretório de
+// trabalho nem de copiar templates/ para a imagem, e são lidos uma única vez.
+//
+// Os horários são gravados e expostos na API em UTC; só a interface os
+// converte para DISPLAY_TIMEZONE (ou TZ), UTC se nenhum estiver definido.
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var (
+	indexTemplate   *template.Template
+	displayLocation = time.UTC
+)
+
+var templateFuncs = template.FuncMap{
+	"expiresIn": expiresIn,
+}
+
+// initTemplates interpreta os templates na inicialização; um template
+// quebrado impede o servidor de subir em vez de virar 500 na primeira visita
+// (o handler só executa o template já carregado)
+func initTemplates() {
+	name := os.Getenv("DISPLAY_TIMEZONE")
+	if name == "" {
+		name = os.Getenv("TZ")
+	}
+	if name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			fatal("DISPLAY_TIMEZONE inválido", "value", name, "error", err)
+		}
+		displayLocation = loc
+	}
+
+	var err error
+	indexTemplate, err = template.New("index.html").Funcs(templateFuncs).ParseFS(templateFS, "templates/index.html")
+	if err != nil {
+		fatal("Erro ao carregar templates", "error", err)
+	}
+
+	// Renderiza uma vez com dados vazios: pega campos e funções inexistentes,
+	// que o parse sozinho não detecta
+	if err := indexTemplate.Execute(io.Discard, IndexData{}); err != nil {
+		fatal("Erro ao renderizar template", "template", "index.html", "error", err)
+	}
+}
+
+// localizeEntries converte os horários para o fuso de exibição. ExpiresAt já
+// vem com o fallback para created_at aplicado por scanEmail, então os dois
+// campos são convertidos juntos.
+func localizeEntries(emails []EmailEntry) {
+	for i := range emails {
+		e := &emails[i]
+		e.CreatedAt = e.CreatedAt.In(displayLocation)
+		e.ExpiresAt = e.ExpiresAt.In(displayLocation)
+		if e.DeletedAt != nil {
+			t := e.DeletedAt.In(displayLocation)
+			e.DeletedAt = &t
+		}
+	}
+}
+
+// expiresIn descreve o tempo restante ("expira em 42m"), usado como texto
+// inicial do countdown antes do JavaScript assumir
+func expiresIn(t time.Time) string {
+	d := time.Until(t).Round(time.Second)
+	switch {
+	case d <= 0:
+		return "expirado"
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("expira em %dd %dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("expira em %dh %dm", d/time.Hour, d%time.Hour/time.Minute)
+	case d >= time.Minute:
+		return fmt.Sprintf("expira em %dm", d/time.Minute)
+	}
+	return fmt.Sprintf("expira em %ds", d/time.Second)
+}
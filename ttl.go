@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTLPreset é uma duração comum oferecida como atalho na UI e na API
+type TTLPreset struct {
+	Key     string        `json:"key"`
+	Seconds int64         `json:"seconds"`
+	TTL     time.Duration `json:"-"`
+}
+
+var (
+	ttlPresets  []TTLPreset
+	presetsOnly bool
+
+	// Limites globais de TTL (MIN_TTL/MAX_TTL)
+	minTTL = 1 * time.Minute
+	maxTTL = 7 * 24 * time.Hour
+
+	// defaultTTL (DEFAULT_TTL) vale quando a requisição não informa ttl e para
+	// registros antigos sem ttl_seconds
+	defaultTTL = 1 * time.Hour
+)
+
+// initTTLPresets carrega TTL_PRESETS (ex.: "10m,1h,1d,1w") e TTL_PRESETS_ONLY
+func initTTLPresets() {
+	raw := os.Getenv("TTL_PRESETS")
+	if raw == "" {
+		raw = "10m,1h,1d,1w"
+	}
+
+	for _, key := range strings.Split(raw, ",") {
+		key = strings.TrimSpace(key)
+		d, err := parseTTL(key)
+		if err != nil {
+			fatal("TTL_PRESETS inválido", "error", err)
+		}
+		ttlPresets = append(ttlPresets, TTLPreset{Key: key, Seconds: int64(d.Seconds()), TTL: d})
+	}
+
+	presetsOnly = envBool("TTL_PRESETS_ONLY", false)
+
+	for key, target := range map[string]*time.Duration{"MIN_TTL": &minTTL, "MAX_TTL": &maxTTL, "DEFAULT_TTL": &defaultTTL} {
+		if v := os.Getenv(key); v != "" {
+			d, err := parseTTL(v)
+			if err != nil {
+				fatal(key+" inválido", "error", err)
+			}
+			*target = d
+		}
+	}
+	if minTTL > maxTTL {
+		fatal("MIN_TTL maior que MAX_TTL", "min_ttl", minTTL.String(), "max_ttl", maxTTL.String())
+	}
+	if defaultTTL < minTTL || defaultTTL > maxTTL {
+		fatal("DEFAULT_TTL fora dos limites de MIN_TTL/MAX_TTL", "default_ttl", formatTTL(defaultTTL), "min_ttl", formatTTL(minTTL), "max_ttl", formatTTL(maxTTL))
+	}
+}
+
+// parseTTL aceita durações do Go (10m, 2h) e também dias/semanas (1d, 1w)
+func parseTTL(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(s, "d"), strings.HasSuffix(s, "w"):
+		n, convErr := strconv.Atoi(s[:len(s)-1])
+		if convErr != nil {
+			return 0, fmt.Errorf("duração inválida: %q", s)
+		}
+		unit := 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			unit *= 7
+		}
+		d = time.Duration(n) * unit
+	default:
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("duração inválida: %q", s)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duração deve ser positiva: %q", s)
+	}
+	return d, nil
+}
+
+// resolveTTL converte o parâmetro ttl da requisição na duração a usar: um
+// preset, uma duração (10m, 2h, 1d) ou um inteiro em minutos. Vazio usa
+// DEFAULT_TTL (padrão 1 hora).
+func resolveTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultTTL, nil
+	}
+	for _, p := range ttlPresets {
+		if p.Key == value {
+			return p.TTL, nil
+		}
+	}
+	if presetsOnly {
+		return 0, fmt.Errorf("ttl deve ser um dos presets configurados")
+	}
+	if minutes, err := strconv.Atoi(value); err == nil {
+		if minutes <= 0 {
+			return 0, fmt.Errorf("ttl deve ser positivo: %q", value)
+		}
+		return time.Duration(minutes) * time.Minute, nil
+	}
+	return parseTTL(value)
+}
+
+// formatTTL é o inverso de parseTTL para exibição: usa a maior unidade exata
+// (1w, 2d, 3h, 15m), caindo para o formato do Go quando não houver
+func formatTTL(d time.Duration) string {
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"w", 7 * 24 * time.Hour},
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+	}
+	for _, u := range units {
+		if d >= u.size && d%u.size == 0 {
+			return fmt.Sprintf("%d%s", d/u.size, u.suffix)
+		}
+	}
+	return d.String()
+}
+
+// clampTTL mantém ttl entre MIN_TTL e limit (sem teto quando limit é 0)
+func clampTTL(ttl, limit time.Duration) time.Duration {
+	if ttl < minTTL {
+		return minTTL
+	}
+	if limit > 0 && ttl > limit {
+		return limit
+	}
+	return ttl
+}
+
+// handleConfig expõe a configuração que um front end precisa para montar a UI
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domain":              defaultDomain(),
+		"domains":             emailDomains(),
+		"ttl_presets":         ttlPresets,
+		"ttl_presets_only":    presetsOnly,
+		"min_ttl_seconds":     int64(minTTL.Seconds()),
+		"max_ttl_seconds":     int64(maxTTL.Seconds()),
+		"default_ttl_seconds": int64(defaultTTL.Seconds()),
+		"warnings":            configWarnings,
+	})
+}
+
+// Limite de TTL por tier (ex.: TIER_MAX_TTL="free=1h,premium=1w"). O tier
+// padrão (vazio) usa o MAX_TTL global.
+var tierMaxTTL = map[string]time.Duration{}
+
+func initTiers() {
+	raw := os.Getenv("TIER_MAX_TTL")
+	if raw == "" {
+		return
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			fatal("TIER_MAX_TTL inválido", "value", pair)
+		}
+		d, err := parseTTL(value)
+		if err != nil {
+			fatal("TIER_MAX_TTL inválido", "tier", name, "error", err)
+		}
+		tierMaxTTL[name] = d
+	}
+}
+
+// tierLimit retorna o TTL máximo do tier
+func tierLimit(tier string) (time.Duration, error) {
+	if tier == "" {
+		return maxTTL, nil
+	}
+	limit, ok := tierMaxTTL[tier]
+	if !ok {
+		return 0, fmt.Errorf("tier desconhecido: %s", tier)
+	}
+	return limit, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Webhook: com WEBHOOK_URL definido, cada evento de WEBHOOK_EVENTS (padrão
+// created,expired,deleted, mais expiring quando NOTIFY_BEFORE está ligado) é
+// enviado via POST em JSON. Com WEBHOOK_SECRET o corpo é assinado em
+// X-Signature-256 ("sha256=<hmac hex>").
+
+type webhookPublisher struct {
+	url    string
+	secret []byte
+	events map[string]bool
+	client *http.Client
+}
+
+func newWebhookPublisher(url string) *webhookPublisher {
+	raw := os.Getenv("WEBHOOK_EVENTS")
+	if raw == "" {
+		raw = "created,expired,deleted"
+		if notifyBefore > 0 {
+			raw += ",expiring"
+		}
+	}
+	wanted := map[string]bool{}
+	for _, ev := range strings.Split(raw, ",") {
+		if ev = strings.TrimSpace(ev); ev != "" {
+			wanted[ev] = true
+		}
+	}
+	if notifyBefore > 0 && !wanted["expiring"] {
+		slog.Warn("NOTIFY_BEFORE ligado, mas WEBHOOK_EVENTS não inclui expiring", "events", raw)
+	}
+
+	timeout := 5 * time.Second
+	if v := os.Getenv("WEBHOOK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			fatal("WEBHOOK_TIMEOUT inválido", "value", v)
+		}
+		timeout = d
+	}
+
+	return &webhookPublisher{
+		url:    url,
+		secret: []byte(os.Getenv("WEBHOOK_SECRET")),
+		events: wanted,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *webhookPublisher) Publish(ev LifecycleEvent) error {
+	if !p.events[ev.Event] {
+		return nil
+	}
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	if len(p.secret) > 0 {
+		mac := hmac.New(sha256.New, p.secret)
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}